@@ -0,0 +1,68 @@
+package protocol
+
+import "sync"
+
+// size classes of pooled buffers, buffers larger than the last class are not pooled
+var bufferClasses = []int{1 << 9, 1 << 11, 1 << 13, 1 << 15, 1 << 17, 1 << 19, 1 << 21}
+
+var bufferPools = newBufferPools()
+
+func newBufferPools() []*sync.Pool {
+	pools := make([]*sync.Pool, len(bufferClasses))
+
+	for i, size := range bufferClasses {
+		size := size
+
+		pools[i] = &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		}
+	}
+
+	return pools
+}
+
+// bufferClass returns index of the smallest class can hold n bytes, -1 if none
+func bufferClass(n int) int {
+	for i, size := range bufferClasses {
+		if n <= size {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// GetBuffer returns a buffer of length n from size-classed pools
+func GetBuffer(n int) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	i := bufferClass(n)
+
+	if i < 0 {
+		return make([]byte, n)
+	}
+
+	b := bufferPools[i].Get().(*[]byte)
+
+	return (*b)[:n]
+}
+
+// PutBuffer puts buffer got from GetBuffer back to pools, the buffer must not be used anymore
+func PutBuffer(b []byte) {
+	c := cap(b)
+
+	// only buffers allocated by pools are accepted
+	i := bufferClass(c)
+
+	if i < 0 || bufferClasses[i] != c {
+		return
+	}
+
+	b = b[:c]
+	bufferPools[i].Put(&b)
+}
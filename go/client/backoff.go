@@ -0,0 +1,95 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var defaultBackoffFactor = 2.0
+
+// minBackoffBase is the least Base of ExponentialBackoff, so reconnect won't spin against server
+const minBackoffBase = time.Millisecond * 100
+
+// Backoff decides how long to wait before next reconnect attempt
+type Backoff interface {
+	// Next returns delay before the attempt-th retry, attempt starts from 1
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff always waits the same duration
+type ConstantBackoff time.Duration
+
+// Next implements Backoff
+func (b ConstantBackoff) Next(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff grows delay exponentially until Max, Base less than 100ms is raised to it.
+// With Jitter the delay is randomized in [0, delay) to avoid clients reconnecting in lockstep
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Factor float64
+	Jitter bool
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewExponentialBackoff returns exponential backoff with full jitter
+func NewExponentialBackoff(base, max time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		Base:   base,
+		Max:    max,
+		Factor: defaultBackoffFactor,
+		Jitter: true,
+	}
+}
+
+// Next implements Backoff
+func (b *ExponentialBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	factor := b.Factor
+
+	if factor < 1 {
+		factor = defaultBackoffFactor
+	}
+
+	base := b.Base
+
+	if base < minBackoffBase {
+		base = minBackoffBase
+	}
+
+	d := float64(base) * math.Pow(factor, float64(attempt-1))
+
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	// delay overflows without Max, float64 of MaxInt64 is rounded up so it is compared by <
+	delay := time.Duration(math.MaxInt64)
+
+	if d < float64(math.MaxInt64) {
+		delay = time.Duration(d)
+	}
+
+	if !b.Jitter || delay < 1 {
+		return delay
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// global source of math/rand is not seeded, so use own source
+	if b.rnd == nil {
+		b.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return time.Duration(b.rnd.Int63n(int64(delay)))
+}
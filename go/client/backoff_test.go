@@ -0,0 +1,38 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{Base: time.Second, Max: time.Second * 5, Factor: 2}
+
+	assert.Equal(t, time.Second, b.Next(1))
+	assert.Equal(t, time.Second*2, b.Next(2))
+	assert.Equal(t, time.Second*4, b.Next(3))
+	assert.Equal(t, time.Second*5, b.Next(4))
+
+	// delay is capped without Max
+	b = &ExponentialBackoff{Base: time.Second, Factor: 2}
+
+	assert.Equal(t, time.Duration(math.MaxInt64), b.Next(100))
+	assert.Equal(t, time.Duration(math.MaxInt64), b.Next(5000))
+
+	b = NewExponentialBackoff(time.Second, time.Second*5)
+
+	for i := 1; i < 10; i++ {
+		d := b.Next(i)
+		assert.True(t, d >= 0 && d < time.Second*5)
+	}
+}
+
+func TestExponentialBackoffMinBase(t *testing.T) {
+	b := &ExponentialBackoff{Factor: 2}
+
+	assert.Equal(t, minBackoffBase, b.Next(1))
+	assert.Equal(t, minBackoffBase*2, b.Next(2))
+}
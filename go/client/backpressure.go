@@ -0,0 +1,144 @@
+package client
+
+import (
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// ErrReadQueueFull means packets are read faster than handled
+var ErrReadQueueFull = errors.New("read queue full")
+
+// BackpressurePolicy decides what to do when read packet queue is full
+type BackpressurePolicy int
+
+const (
+	// BackpressureDropPush drops push packets, responses and control packets wait for room of their own queue
+	BackpressureDropPush BackpressurePolicy = iota
+	// BackpressureBlock stops reading conn until queue has room, so the server is slowed down by tcp flow control
+	BackpressureBlock
+	// BackpressureClose closes conn, client reconnects later
+	BackpressureClose
+)
+
+var backpressurePolicyStrings = []string{"drop-push", "block", "close"}
+
+func (p BackpressurePolicy) String() string {
+	if p < 0 || int(p) >= len(backpressurePolicyStrings) {
+		return "unknown"
+	}
+
+	return backpressurePolicyStrings[int(p)]
+}
+
+// readQueue passes read packets to handler, responses and control packets have their own queue,
+// so they are not stuck behind pushes, e.g. a push subscriber waiting for response of its request
+type readQueue struct {
+	pushCh chan *protocol.Packet
+	respCh chan *protocol.Packet
+}
+
+func newReadQueue(size int) *readQueue {
+	return &readQueue{
+		pushCh: make(chan *protocol.Packet, size),
+		respCh: make(chan *protocol.Packet, size),
+	}
+}
+
+func (q *readQueue) queueOf(p *protocol.Packet) chan *protocol.Packet {
+	if p.IsControl() || p.Metadata.Type == protocol.ResponsePacket {
+		return q.respCh
+	}
+
+	return q.pushCh
+}
+
+// deliver puts packet into queue according to backpressure policy, error means conn should be closed
+func (q *readQueue) deliver(p *protocol.Packet, o *DialOptions, closeCh <-chan struct{}) error {
+	ch := q.queueOf(p)
+
+	select {
+	case ch <- p:
+		return nil
+	default:
+	}
+
+	switch o.Backpressure {
+	case BackpressureClose:
+		return errors.Wrapf(ErrReadQueueFull, "len: %d", len(ch))
+	case BackpressureDropPush:
+		if p.Metadata.Type == protocol.PushPacket && !p.IsControl() {
+			if o.onDrop != nil {
+				o.onDrop(p)
+			}
+			return nil
+		}
+	}
+
+	select {
+	case ch <- p:
+		return nil
+	case <-closeCh:
+		return errConnClosed
+	}
+}
+
+// consume invokes fn with packets of both queues in a single goroutine until closeCh is closed,
+// responses are preferred to pushes, then queued packets are consumed and fn is invoked with errConnClosed at last
+func (q *readQueue) consume(fn func(*protocol.Packet, error), closeCh <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case p := <-q.respCh:
+				fn(p, nil)
+				continue
+			default:
+			}
+
+			select {
+			case p := <-q.respCh:
+				fn(p, nil)
+			case p := <-q.pushCh:
+				fn(p, nil)
+			case <-closeCh:
+				// consume all packet
+				for l := len(q.respCh); l > 0; l-- {
+					fn(<-q.respCh, nil)
+				}
+				for l := len(q.pushCh); l > 0; l-- {
+					fn(<-q.pushCh, nil)
+				}
+				fn(nil, errConnClosed)
+				return
+			}
+		}
+	}()
+}
+
+// DroppedPushes return count of push data dropped for read queue full per cmd
+func (c *client) DroppedPushes() map[uint32]uint64 {
+	c.droppedMu.Lock()
+	defer c.droppedMu.Unlock()
+
+	m := make(map[uint32]uint64, len(c.dropped))
+
+	for cmd, n := range c.dropped {
+		m[cmd] = n
+	}
+
+	return m
+}
+
+func (c *client) handleDroppedPush(packet *protocol.Packet) {
+	c.droppedMu.Lock()
+	c.dropped[packet.CMD()]++
+	n := c.dropped[packet.CMD()]
+	c.droppedMu.Unlock()
+
+	// only warn at the first time to avoid flooding logs
+	if n == 1 {
+		c.Logger.Warnf("drop push for read queue full, cmd: %d", packet.CMD())
+	} else {
+		c.Logger.Debugf("drop push for read queue full, cmd: %d, count: %d", packet.CMD(), n)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestReadQueue(t *testing.T) {
+	ctx := protocol.NewContext(context.Background(), protocol.ClientSide)
+	ctx.Codec = protocol.CodecProtobuf
+	push := protocol.MustNewPush(ctx, 100, &empty.Empty{})
+	res := protocol.MustNewResponse(ctx, 100, protocol.StatusSuccess, &empty.Empty{}, protocol.WithRequestId(1))
+
+	var dropped int
+
+	o := newDialOptions()
+	o.onDrop = func(*protocol.Packet) {
+		dropped++
+	}
+
+	closeCh := make(chan struct{})
+
+	// drop push only
+	q := newReadQueue(1)
+	assert.Nil(t, q.deliver(&push, o, closeCh))
+	assert.Nil(t, q.deliver(&push, o, closeCh))
+	assert.Equal(t, 1, dropped)
+
+	// response is not queued behind pushes
+	assert.Nil(t, q.deliver(&res, o, closeCh))
+
+	doneCh := make(chan error, 1)
+
+	go func() {
+		doneCh <- q.deliver(&res, o, closeCh)
+	}()
+
+	// response waits for room of its queue
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, len(doneCh))
+	assert.Equal(t, &res, <-q.respCh)
+	assert.Nil(t, <-doneCh)
+	assert.Equal(t, &res, <-q.respCh)
+
+	// close
+	o.Backpressure = BackpressureClose
+	err := q.deliver(&push, o, closeCh)
+	assert.True(t, errors.Is(err, ErrReadQueueFull))
+
+	cause, _ := disconnectCause(newDisconnectError(DisconnectReadError, err))
+	assert.Equal(t, DisconnectReadQueueFull, cause)
+
+	// block until conn closed
+	o.Backpressure = BackpressureBlock
+
+	go func() {
+		doneCh <- q.deliver(&push, o, closeCh)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	close(closeCh)
+	assert.Equal(t, errConnClosed, <-doneCh)
+	assert.Equal(t, 1, dropped)
+
+	// responses are consumed before queued pushes, one packet at a time
+	q = newReadQueue(2)
+	closeCh = make(chan struct{})
+	o.Backpressure = BackpressureDropPush
+	assert.Nil(t, q.deliver(&push, o, closeCh))
+	assert.Nil(t, q.deliver(&push, o, closeCh))
+	assert.Nil(t, q.deliver(&res, o, closeCh))
+
+	var (
+		types   []protocol.PacketType
+		running int32
+	)
+
+	errCh := make(chan error, 1)
+
+	q.consume(func(p *protocol.Packet, err error) {
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			t.Error("packets consumed concurrently")
+		}
+		defer atomic.StoreInt32(&running, 0)
+
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		types = append(types, p.Metadata.Type)
+	}, closeCh)
+
+	time.Sleep(time.Millisecond * 50)
+	close(closeCh)
+	assert.Equal(t, errConnClosed, <-errCh)
+	assert.Equal(t, []protocol.PacketType{protocol.ResponsePacket, protocol.PushPacket, protocol.PushPacket}, types)
+}
+
+func TestClientDroppedPushes(t *testing.T) {
+	c, _ := newClientAndDial()
+	cli := c.(*client)
+
+	p := protocol.MustNewPush(cli.conn.Context(), 100, &empty.Empty{})
+
+	cli.dialOptions.onDrop(&p)
+	cli.dialOptions.onDrop(&p)
+
+	assert.Equal(t, map[uint32]uint64{100: 2}, c.DroppedPushes())
+}
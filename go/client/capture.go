@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// CaptureDirection is direction of captured packet
+type CaptureDirection uint8
+
+const (
+	CaptureInbound CaptureDirection = iota + 1
+	CaptureOutbound
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureInbound:
+		return "inbound"
+	case CaptureOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// record header: direction:8, version:8, codec:8, timestamp:64, frame_len:32
+const captureHeaderLen = 15
+
+var ErrInvalidCapture = errors.New("invalid capture record")
+
+// CaptureRecord is a packet captured by Recorder
+type CaptureRecord struct {
+	Direction CaptureDirection
+	Version   uint8
+	Codec     protocol.CodecType
+	Time      time.Time
+	// Frame is the binary frame of packet, packed by protocol of Version
+	Frame []byte
+}
+
+// Unpack unpack frame of record to packet
+func (r *CaptureRecord) Unpack() (*protocol.Packet, error) {
+	p, err := protocol.GetProtocol(r.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := protocol.NewContext(context.Background(), protocol.ClientSide)
+	ctx.Version = r.Version
+	ctx.Codec = r.Codec
+
+	return p.UnpackBytes(ctx, r.Frame)
+}
+
+// Recorder writes packets to an append-only capture file
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewRecorder open capture file for appending, the file will be created if not exists
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "open capture file")
+	}
+
+	return &Recorder{f: f}, nil
+}
+
+// Record append frame read from or written to conn of ctx to capture file
+func (r *Recorder) Record(dir CaptureDirection, ctx *protocol.Context, frame []byte) error {
+	data := make([]byte, captureHeaderLen+len(frame))
+	data[0] = uint8(dir)
+	data[1] = ctx.Version
+	data[2] = uint8(ctx.Codec)
+	binary.BigEndian.PutUint64(data[3:11], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(data[11:15], uint32(len(frame)))
+	copy(data[captureHeaderLen:], frame)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// write a whole record at once, so it won't be interleaved
+	_, err := r.f.Write(data)
+
+	return err
+}
+
+// Close close capture file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.f.Close()
+}
+
+// CaptureReader reads records from capture file
+type CaptureReader struct {
+	r   io.Reader
+	hdr [captureHeaderLen]byte
+}
+
+// NewCaptureReader returns a reader of capture records
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: r}
+}
+
+// Next returns next record, io.EOF will be returned at the end of capture
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	if _, err := io.ReadFull(cr.r, cr.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrInvalidCapture
+		}
+		return nil, err
+	}
+
+	rec := &CaptureRecord{
+		Direction: CaptureDirection(cr.hdr[0]),
+		Version:   cr.hdr[1],
+		Codec:     protocol.CodecType(cr.hdr[2]),
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(cr.hdr[3:11]))),
+		Frame:     make([]byte, binary.BigEndian.Uint32(cr.hdr[11:15])),
+	}
+
+	if _, err := io.ReadFull(cr.r, rec.Frame); err != nil {
+		return nil, ErrInvalidCapture
+	}
+
+	return rec, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
@@ -16,6 +17,8 @@ import (
 var (
 	ErrSessExpired     = errors.New("session expired")
 	ErrHitMaxReconnect = errors.New("hit max reconnect count")
+	ErrClientClosed    = errors.New("client closed")
+	ErrWriteQueueFull  = errors.New("write queue full")
 
 	errConnClosed = errors.New("client conn closed")
 )
@@ -30,16 +33,44 @@ type Client interface {
 	Do(ctx context.Context, req *Request, opts ...RequestOption) (*protocol.Packet, error)
 	// Subscribe using to register handle of push data
 	Subscribe(cmd uint32, sub func(*protocol.Packet))
+	// SubscribeConflated using to register handle of push data which only cares about the latest packet per key
+	SubscribeConflated(cmd uint32, key ConflateKeyFunc, sub func(*protocol.Packet), opts ...ConflateOption) *Conflater
+	// SubscribeAll using to register handle of all push data
+	SubscribeAll(sub func(*protocol.Packet))
+	// OnUnhandledPush using to handle push data which has no subscriber of its cmd
+	OnUnhandledPush(fn func(*protocol.Packet))
+	// UnhandledPushes return count of unhandled push data per cmd
+	UnhandledPushes() map[uint32]uint64
+	// DroppedPushes return count of push data dropped for read queue full per cmd
+	DroppedPushes() map[uint32]uint64
+	// HandleRequest using to register handle of request initiated by server
+	HandleRequest(cmd uint32, h RequestHandler)
 	// AfterReconnected using to handle client after reconnected
 	AfterReconnected(fn func())
+	// OnConnEvent using to handle disconnect and reconnect events of client
+	OnConnEvent(fn func(ev ConnEvent))
+	// OnSessionRefresh using to handle result of proactive session refresh
+	OnSessionRefresh(fn func(info *control.AuthResponse, err error))
 	// OnPing using to custom handle ping packet
 	OnPing(fn func(*protocol.Packet))
 	// OnPong using to custom handle pong packet
 	OnPong(fn func(*protocol.Packet))
+	// Latency return statistics of heartbeat round-trip time
+	Latency() LatencyStats
+	// ProtocolVersion return protocol version of conn
+	ProtocolVersion() uint8
 	// OnClose using to handle client close
 	OnClose(fn func(err error))
+	// State return current connection state
+	State() State
+	// OnStateChange using to handle connection state change
+	OnStateChange(fn func(old, new State, reason string))
+	// WaitReady blocks until client is authenticated and usable
+	WaitReady(ctx context.Context) error
 	// Close used to close conn between server
 	Close(err error) error
+	// Shutdown waits for in-flight requests, notifies server and closes client
+	Shutdown(ctx context.Context, code control.Close_Code, reason string) error
 }
 
 // Request represents an socket request to server
@@ -49,12 +80,21 @@ type Request struct {
 	Metadata map[string]string
 }
 
+// RequestHandler handles request initiated by server,
+// the returned body and status code will be sent back as response
+type RequestHandler func(*protocol.Packet) (body proto.Message, status uint8)
+
 // New returns a new client instance
 func New(opts ...ClientOption) Client {
 	c := &client{
-		closeCh: make(chan struct{}),
-		subs:    make(map[uint32][]func(*protocol.Packet)),
-		recvs:   make(map[uint32]chan *protocol.Packet),
+		closeCh:   make(chan struct{}),
+		readyCh:   make(chan struct{}),
+		subs:      make(map[uint32][]func(*protocol.Packet)),
+		handlers:  make(map[uint32]RequestHandler),
+		unhandled: make(map[uint32]uint64),
+		dropped:   make(map[uint32]uint64),
+		recvs:     make(map[uint32]chan *protocol.Packet),
+		latency:   newLatencyWindow(defaultLatencyWindow),
 	}
 
 	for _, opt := range opts {
@@ -73,6 +113,9 @@ func New(opts ...ClientOption) Client {
 
 // client is an socket client
 type client struct {
+	// lastPongAt is unix nano of last pong, it is accessed atomically so keep it 64-bit aligned
+	lastPongAt int64
+
 	sync.RWMutex
 
 	Context context.Context
@@ -80,7 +123,13 @@ type client struct {
 
 	conn ClientConn
 
-	closeCh chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// guard of shutting and inflight
+	shutdownMu sync.RWMutex
+	shutting   bool
+	inflight   sync.WaitGroup
 
 	// custom ping packet handler
 	onPing func(*protocol.Packet)
@@ -91,49 +140,124 @@ type client struct {
 	onClose func(err error)
 
 	afterReconnected func()
+	onConnEvent      func(ev ConnEvent)
+
+	onSessionRefresh func(info *control.AuthResponse, err error)
+
+	stateMu       sync.Mutex
+	state         State
+	readyCh       chan struct{}
+	onStateChange func(old, new State, reason string)
 
 	authInfo  *control.AuthResponse
 	handshake *protocol.Handshake
+	// version is protocol version of conn, handshake of caller is not changed
+	version uint8
 
 	subs map[uint32][]func(*protocol.Packet)
 
+	// subscriber of all push data
+	subAll []func(*protocol.Packet)
+
+	// handle push data without subscriber
+	onUnhandledPush func(*protocol.Packet)
+
+	unhandledMu sync.Mutex
+	unhandled   map[uint32]uint64
+
+	droppedMu sync.Mutex
+	dropped   map[uint32]uint64
+
+	handlers map[uint32]RequestHandler
+
+	conflaters []*Conflater
+
 	recvsMu sync.RWMutex
 	recvs   map[uint32]chan *protocol.Packet
 
 	lastKeepaliveId uint32
-	lastPongAt      time.Time
+	latency         *latencyWindow
 	reconnectCount  int
 	doReconnectting bool
 
+	// peerCompressions is bits of compressions used by peer of conn, it is accessed atomically
+	peerCompressions uint32
+
+	recorder *Recorder
+
+	verifier *protocol.Verifier
+
+	sessionStore SessionStore
+
 	addr            *url.URL
+	endpoints       *endpoints
 	dialOptions     *DialOptions
 	connectMetadata map[string]string
 }
 
 // Dial using to dial with server
 func (c *client) Dial(ctx context.Context, u string, handshake *protocol.Handshake, opts ...DialOption) error {
-	c.handshake = handshake
+	dopts := newDialOptions(opts...)
 
-	uri, err := url.Parse(u)
+	// handshake of caller is not changed
+	h := *handshake
 
-	if err != nil {
-		return errors.Wrap(err, "parse dial url")
+	for _, t := range dopts.AcceptCompressions {
+		if _, err := protocol.GetCompressor(t); err == nil {
+			h.AcceptCompression(t)
+		}
 	}
 
-	c.addr = uri
+	c.handshake = &h
+	dopts.onDrop = c.handleDroppedPush
+	dopts.onHandshake = func() {
+		c.setState(StateHandshaking, "conn established")
+	}
 
-	dialer, ok := GetDialer(uri.Scheme)
+	if c.recorder != nil {
+		dopts.onFrame = c.record
+	}
 
-	if !ok {
-		return errors.Errorf("dialer for scheme %s not exists", uri.Scheme)
+	c.dialOptions = dopts
+
+	if dopts.Signer != nil && dopts.VerifyWindow > 0 {
+		c.verifier = protocol.NewVerifier(dopts.Signer, dopts.VerifyWindow)
 	}
 
-	dopts := newDialOptions(opts...)
+	eps, err := newEndpoints(u, dopts)
 
-	c.dialOptions = dopts
+	if err != nil {
+		return err
+	}
+
+	c.endpoints = eps
+
+	if err = eps.reset(ctx); err != nil {
+		return err
+	}
 
 	c.Logger.Debug("get conn")
-	if err = c.dial(ctx, dialer); err != nil {
+
+	// try each endpoint once, move to next one if dialing or auth failed
+	for i, n := 0, eps.len(); i < n; i++ {
+		c.addr = eps.current()
+
+		if err = c.connect(ctx); err == nil {
+			break
+		}
+
+		c.Logger.Warnf("failed to connect %s, err: %v", c.addr, err)
+
+		// no more endpoint is tried if ctx is done
+		if ctx.Err() != nil {
+			break
+		}
+
+		eps.next()
+	}
+
+	if err != nil {
+		c.setState(StateIdle, err.Error())
 		return err
 	}
 
@@ -143,19 +267,85 @@ func (c *client) Dial(ctx context.Context, u string, handshake *protocol.Handsha
 		go c.keepalive()
 	}
 
-	return c.auth()
+	if c.dialOptions.SessionRefreshBefore > 0 && c.dialOptions.AuthTokenGetter != nil {
+		go c.refreshing()
+	}
+
+	return nil
 }
 
 func (c *client) AuthInfo() *control.AuthResponse {
+	c.RLock()
+	defer c.RUnlock()
+
 	return c.authInfo
 }
 
-func (c *client) dial(ctx context.Context, dialer DialConnFunc) (err error) {
+// connect dials current endpoint and does auth on it, conn is closed if auth failed
+func (c *client) connect(ctx context.Context) (err error) {
+	dialer, _ := GetDialer(c.addr.Scheme)
+
+	if err = c.dial(ctx, dialer); err != nil {
+		return
+	}
+
+	if c.sessionStore != nil && c.dialOptions.AuthTokenGetter != nil {
+		if err = c.resumeStoredSession(ctx); err == nil {
+			return
+		}
+
+		c.Logger.Infof("failed to resume stored session, do auth, err: %v", err)
+	}
+
+	if err = c.auth(ctx); err != nil {
+		c.RLock()
+		conn := c.conn
+		c.RUnlock()
+
+		conn.Close(errors.Wrap(err, "close conn failed to auth"))
+	}
+
+	return
+}
+
+func (c *client) dial(ctx context.Context, dialer DialConnFunc) error {
+	if c.dialOptions.NegotiateVersion {
+		return c.negotiate(ctx, dialer)
+	}
+
+	return c.dialVersion(ctx, dialer, c.handshake.Version, nil)
+}
+
+// dialVersion dials with protocol version ver, packets and close of conn are passed to probe first if it is set
+func (c *client) dialVersion(ctx context.Context, dialer DialConnFunc, ver uint8, probe *versionProbe) (err error) {
+	c.setState(StateDialing, "dial "+c.addr.String())
+
 	c.Lock()
 	defer c.Unlock()
-	if c.conn, err = dialer(ctx, c.Logger, c.addr, c.handshake, c.dialOptions); err == nil {
+
+	c.version = ver
+	atomic.StoreUint32(&c.peerCompressions, 0)
+
+	h := *c.handshake
+	h.Version = ver
+
+	if c.conn, err = dialer(ctx, c.Logger, c.addr, &h, c.dialOptions); err != nil {
+		return
+	}
+
+	if probe == nil {
 		c.conn.OnPacket(c.onPacket)
 		c.conn.OnClose(c.onConnClose)
+		// conn may not report its handshake
+		c.setState(StateHandshaking, "conn established")
+		return
+	}
+
+	c.conn.OnPacket(probe.wrapPacket(c.onPacket))
+	c.conn.OnClose(probe.wrapClose(c.onConnClose))
+
+	if err = c.sendProbe(probe); err != nil {
+		c.conn.Close(errors.Wrap(err, "close conn failed to send probe"))
 	}
 
 	return
@@ -168,40 +358,71 @@ func (c *client) onConnClose(err error) {
 	default:
 	}
 
+	// replay is not redialed after capture is exhausted
+	if c.addr.Scheme == "replay" {
+		c.Close(err)
+		return
+	}
+
 	c.Logger.Debugf("reconnect for conn closed: %v", err)
 
-	c.reconnecting()
+	c.reconnecting(newDisconnectError(DisconnectConnClosed, err))
 }
 
-func (c *client) auth() error {
+func (c *client) auth(ctx context.Context) error {
 	if c.dialOptions.AuthTokenGetter == nil {
+		c.setState(StateReady, "no auth required")
 		return nil
 	}
-	token, err := c.dialOptions.AuthTokenGetter()
+
+	c.setState(StateAuthenticating, "auth")
+
+	info, err := c.doAuth(ctx)
+
 	if err != nil {
 		return err
 	}
-	res, err := c.Do(context.Background(), &Request{
+
+	c.setAuthInfo(info)
+
+	c.setState(StateReady, "auth success")
+
+	return nil
+}
+
+func (c *client) doAuth(ctx context.Context) (*control.AuthResponse, error) {
+	token, err := c.dialOptions.AuthTokenGetter()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(ctx, &Request{
 		Cmd:  uint32(control.Command_CMD_AUTH),
 		Body: &control.AuthRequest{Token: token, Metadata: c.connectMetadata},
 	}, RequestTimeout(c.dialOptions.AuthTimeout))
 
 	if err != nil {
-		return errors.Wrap(err, "do auth")
+		return nil, errors.Wrap(err, "do auth")
 	}
 
 	var info control.AuthResponse
 
 	if err = res.Unmarshal(&info); err != nil {
-		return errors.Wrap(err, "auth unmarshal res")
+		return nil, errors.Wrap(err, "auth unmarshal res")
 	}
 
-	c.authInfo = &info
+	res.Release()
 
-	return nil
+	return &info, nil
 }
 
-func (c *client) reconnecting() {
+func (c *client) reconnecting(cause error) {
+	// conn broken before client gets ready is handled by Dial or the running reconnect
+	switch c.State() {
+	case StateIdle, StateDialing, StateHandshaking, StateAuthenticating:
+		c.Logger.Warnf("conn broken before ready, err: %v", cause)
+		return
+	}
+
 	c.Lock()
 	if c.doReconnectting {
 		c.Unlock()
@@ -211,6 +432,17 @@ func (c *client) reconnecting() {
 	c.doReconnectting = true
 	c.Unlock()
 
+	c.setState(StateReconnecting, cause.Error())
+
+	disconnectedAt := time.Now()
+	ev := ConnEvent{Endpoint: c.addr.String()}
+	ev.Cause, ev.CloseCode = disconnectCause(cause)
+
+	c.Logger.Warnf("disconnected, cause: %s, err: %v", ev.Cause, cause)
+
+	ev.Type, ev.Err = ConnEventDisconnected, cause
+	c.emitConnEvent(ev)
+
 	waitCh := make(chan struct{})
 
 	go func() {
@@ -218,13 +450,43 @@ func (c *client) reconnecting() {
 			waitCh <- struct{}{}
 		}()
 
-		for {
+		// the first attempt is delayed as well, so clients disconnected together won't reconnect in lockstep
+		d := c.dialOptions.ReconnectBackoff.Next(1)
+
+		for attempt := 1; ; attempt++ {
+			t := time.NewTimer(d)
+
+			select {
+			case <-c.closeCh:
+				t.Stop()
+				return
+			case <-c.Context.Done():
+				t.Stop()
+				c.Logger.Error("close client for context done")
+				c.Close(c.Context.Err())
+				return
+			case <-t.C:
+			}
+
 			c.Logger.Info("start reconnecting.")
 
-			err := c.reconnect()
+			if attempt == 1 {
+				if err := c.endpoints.reset(c.Context); err != nil {
+					c.Logger.Errorf("failed to reset endpoints, err: %v", err)
+				}
+			}
+
+			resumed, err := c.reconnect()
+
+			ev.Attempt, ev.Err, ev.Endpoint = attempt, err, c.addr.String()
+			ev.Outage = time.Since(disconnectedAt)
 
 			if err == nil {
-				c.Logger.Info("reconnect success")
+				c.Logger.Infof("reconnect success, attempt: %d, resumed: %v, outage: %s", attempt, resumed, ev.Outage)
+
+				ev.Type, ev.Resumed = ConnEventReconnected, resumed
+				c.emitConnEvent(ev)
+
 				if c.afterReconnected != nil {
 					c.afterReconnected()
 				}
@@ -233,14 +495,25 @@ func (c *client) reconnecting() {
 
 			if err == ErrHitMaxReconnect {
 				c.Logger.Error("close client for hit max reconnect count")
+
+				ev.Type = ConnEventReconnectFailed
+				c.emitConnEvent(ev)
+
 				c.Close(err)
 				return
 			}
 
-			c.Logger.Errorf("reconnect failed, err: %v", err)
+			c.setState(StateReconnecting, err.Error())
+
+			c.endpoints.fail()
+
+			d = c.dialOptions.ReconnectBackoff.Next(attempt + 1)
+
+			c.Logger.Errorf("reconnect failed, retry after %s, err: %v", d, err)
 
-			// TODO: dynamic value for sleepping
-			time.Sleep(time.Second * 1)
+			ev.Type, ev.Backoff = ConnEventReconnectFailed, d
+			c.emitConnEvent(ev)
+			ev.Backoff = 0
 		}
 	}()
 
@@ -251,10 +524,11 @@ func (c *client) reconnecting() {
 	c.Unlock()
 }
 
-func (c *client) reconnect() error {
+// reconnect returns whether the session is resumed
+func (c *client) reconnect() (resumed bool, err error) {
 	if c.dialOptions.MaxReconnect > 0 {
 		if c.reconnectCount >= c.dialOptions.MaxReconnect {
-			return ErrHitMaxReconnect
+			return false, ErrHitMaxReconnect
 		}
 	}
 
@@ -271,66 +545,87 @@ func (c *client) reconnect() error {
 	c.recvs = make(map[uint32]chan *protocol.Packet)
 	c.recvsMu.Unlock()
 
+	c.latency.reset()
+
+	c.addr = c.endpoints.current()
 	dialer, _ := GetDialer(c.addr.Scheme)
 
 	ctx, cancel := context.WithTimeout(c.Context, c.dialOptions.Timeout)
 	defer cancel()
 
-	if err := c.dial(ctx, dialer); err != nil {
-		return err
+	if err = c.dial(ctx, dialer); err != nil {
+		return
 	}
 
 	// server needn't auth
-	if c.authInfo == nil {
-		return nil
+	if c.AuthInfo() == nil {
+		c.setState(StateReady, "no auth required")
+		return
 	}
 
 	// do auth
 	if c.isAuthExpired() {
-		return c.auth()
+		return false, c.auth(c.Context)
 	}
 
-	return c.reconnectDial()
+	return c.reconnectDial(c.Context)
 }
 
-func (c *client) reconnectDial() error {
-	res, err := c.Do(c.Context, &Request{Cmd: uint32(control.Command_CMD_RECONNECT), Body: &control.ReconnectRequest{
-		SessionId: c.authInfo.SessionId,
+func (c *client) reconnectDial(ctx context.Context) (resumed bool, err error) {
+	c.setState(StateAuthenticating, "resume session")
+
+	res, err := c.Do(ctx, &Request{Cmd: uint32(control.Command_CMD_RECONNECT), Body: &control.ReconnectRequest{
+		SessionId: c.AuthInfo().GetSessionId(),
 		Metadata:  c.connectMetadata,
 	}}, RequestTimeout(c.dialOptions.AuthTimeout))
 
 	if err != nil {
-		return errors.Wrap(err, "reconnect request")
+		return false, errors.Wrap(err, "reconnect request")
 	}
 
 	if res.StatusCode() == protocol.StatusUnauthenticated {
-		return c.auth()
+		return false, c.auth(ctx)
 	}
 
 	var info control.AuthResponse
 
 	if err = res.Unmarshal(&info); err != nil {
-		return errors.Wrap(err, "reconnect unmarshal")
+		return false, errors.Wrap(err, "reconnect unmarshal")
 	}
 
-	c.authInfo = &info
+	res.Release()
+
+	c.setAuthInfo(&info)
 	c.reconnectCount = 0
-	c.lastKeepaliveId = 0
-	return nil
+	atomic.StoreUint32(&c.lastKeepaliveId, 0)
+
+	c.setState(StateReady, "session resumed")
+	return true, nil
 }
 
 func (c *client) isAuthExpired() bool {
-	if c.authInfo == nil {
+	info := c.AuthInfo()
+
+	if info == nil {
 		return true
 	}
 
-	expireAt := time.Unix(c.authInfo.GetExpires()/1000-10, c.authInfo.GetExpires()%1000*int64(time.Millisecond))
+	expireAt := expiresAt(info).Add(-time.Second * 10)
 	return time.Since(expireAt) >= 0
 
 }
 
 // Do will do request to server
 func (c *client) Do(ctx context.Context, req *Request, opts ...RequestOption) (res *protocol.Packet, err error) {
+	c.shutdownMu.RLock()
+	if c.shutting {
+		c.shutdownMu.RUnlock()
+		return nil, ErrClientClosed
+	}
+	c.inflight.Add(1)
+	c.shutdownMu.RUnlock()
+	defer c.inflight.Done()
+
 	c.RLock()
 	defer c.RUnlock()
 	rp, e := protocol.NewRequest(c.conn.Context(), req.Cmd, req.Body)
@@ -349,11 +644,15 @@ func (c *client) Do(ctx context.Context, req *Request, opts ...RequestOption) (r
 	rc, cancel := context.WithTimeout(ctx, ropts.timeout)
 	defer cancel()
 
+	// receiver is added before writing, response may arrive before write returns
+	ch, release := c.addRecv(rp.Metadata.RequestId)
+	defer release()
+
 	if err = c.write(&rp); err != nil {
 		return
 	}
 
-	res, err = c.recv(rc, rp.Metadata.RequestId)
+	res, err = c.recv(rc, rp.Metadata.RequestId, ch)
 	if err != nil {
 		return
 	}
@@ -382,6 +681,46 @@ func (c *client) Subscribe(cmd uint32, sub func(*protocol.Packet)) {
 	}
 }
 
+// SubscribeAll using to register handle of all push data
+// concurrency unsafe, please sub at first time
+func (c *client) SubscribeAll(sub func(*protocol.Packet)) {
+	c.subAll = append(c.subAll, sub)
+}
+
+// OnUnhandledPush using to handle push data which has no subscriber of its cmd
+func (c *client) OnUnhandledPush(fn func(*protocol.Packet)) {
+	c.onUnhandledPush = fn
+}
+
+// UnhandledPushes return count of unhandled push data per cmd
+func (c *client) UnhandledPushes() map[uint32]uint64 {
+	c.unhandledMu.Lock()
+	defer c.unhandledMu.Unlock()
+
+	m := make(map[uint32]uint64, len(c.unhandled))
+
+	for cmd, n := range c.unhandled {
+		m[cmd] = n
+	}
+
+	return m
+}
+
+// SubscribeConflated using to register handle of push data which only cares about the latest packet per key
+// concurrency unsafe, please sub at first time
+func (c *client) SubscribeConflated(cmd uint32, key ConflateKeyFunc, sub func(*protocol.Packet), opts ...ConflateOption) *Conflater {
+	cf := NewConflater(key, sub, opts...)
+	c.conflaters = append(c.conflaters, cf)
+	c.Subscribe(cmd, cf.Push)
+	return cf
+}
+
+// HandleRequest using to register handle of request initiated by server
+// concurrency unsafe, please register at first time
+func (c *client) HandleRequest(cmd uint32, h RequestHandler) {
+	c.handlers[cmd] = h
+}
+
 // OnPing using to custom handle ping packet
 func (c *client) OnPing(fn func(*protocol.Packet)) {
 	c.onPing = fn
@@ -404,19 +743,118 @@ func (c *client) AfterReconnected(fn func()) {
 
 // Close used to close conn between server
 func (c *client) Close(err error) error {
+	closed := true
+
+	c.closeOnce.Do(func() {
+		closed = false
+	})
+
+	if closed {
+		return nil
+	}
+
 	c.Logger.Info("close client")
 	close(c.closeCh)
+
+	reason := "close by client"
+	if err != nil {
+		reason = err.Error()
+	}
+	c.setState(StateClosed, reason)
+
 	c.RLock()
 	if c.conn != nil {
 		c.conn.Close(errors.New("close by client"))
 	}
 	c.RUnlock()
+	for _, cf := range c.conflaters {
+		cf.Close()
+	}
 	if c.onClose != nil {
 		c.onClose(err)
 	}
 	return nil
 }
 
+// Shutdown waits for in-flight requests, notifies server and closes client
+// new requests will fail with ErrClientClosed
+func (c *client) Shutdown(ctx context.Context, code control.Close_Code, reason string) (err error) {
+	c.shutdownMu.Lock()
+	c.shutting = true
+	c.shutdownMu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		c.Logger.Warnf("shutdown without waiting for in-flight requests, err: %v", err)
+	}
+
+	c.RLock()
+	conn := c.conn
+	c.RUnlock()
+
+	// server is notified even if in-flight requests are not drained
+	if conn != nil {
+		sctx := ctx
+
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			sctx, cancel = context.WithTimeout(context.Background(), closeNoticeTimeout)
+			defer cancel()
+		}
+
+		if e := c.sendClose(sctx, conn, code, reason); e != nil {
+			c.Logger.Errorf("failed to notify server of close, err: %v", e)
+
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	c.Close(nil)
+
+	return
+}
+
+// closeNoticeTimeout bounds sending close packet after ctx of Shutdown is done
+const closeNoticeTimeout = time.Second * 3
+
+func (c *client) sendClose(ctx context.Context, conn ClientConn, code control.Close_Code, reason string) error {
+	p, err := protocol.NewPush(conn.Context(), uint32(control.Command_CMD_CLOSE), &control.Close{Code: code, Reason: reason})
+
+	if err != nil {
+		return err
+	}
+
+	f, canFlush := conn.(flusher)
+
+	// make sure close packet is behind pending data
+	if canFlush {
+		if err = f.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err = c.write(&p); err != nil {
+		return err
+	}
+
+	if canFlush {
+		return f.Flush(ctx)
+	}
+
+	return nil
+}
+
 func (c *client) closeByServer(packet *protocol.Packet) {
 	var reason control.Close
 
@@ -426,27 +864,32 @@ func (c *client) closeByServer(packet *protocol.Packet) {
 		c.Logger.Errorf("close by server, code: %v, reason: %s", reason.Code, reason.Reason)
 	}
 
+	cause := &disconnectError{
+		cause: DisconnectServerClose,
+		code:  reason.Code,
+		err:   errors.Errorf("close by server, code: %v, reason: %s", reason.Code, reason.Reason),
+	}
+
 	c.RLock()
 	if c.conn != nil {
-		c.conn.Close(errors.New("close by server"))
+		c.conn.Close(cause)
 	}
 	c.RUnlock()
 
-	c.reconnecting()
+	c.reconnecting(cause)
 }
 
 func (c *client) keepalive() {
 	t := time.NewTicker(c.dialOptions.Keepalive)
 
-	now := time.Now()
-	c.lastPongAt = now
+	atomic.StoreInt64(&c.lastPongAt, time.Now().UnixNano())
 
 	check := func() error {
-		if c.lastKeepaliveId == 0 {
+		if atomic.LoadUint32(&c.lastKeepaliveId) == 0 {
 			return nil
 		}
 
-		if d := time.Since(c.lastPongAt); d > c.dialOptions.KeepaliveTimeout {
+		if d := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastPongAt))); d > c.dialOptions.KeepaliveTimeout {
 			return errors.Errorf("keepalive timeout %s", d.String())
 		}
 
@@ -476,11 +919,13 @@ func (c *client) keepalive() {
 			return err
 		}
 
+		c.latency.ping(id, time.Now(), c.dialOptions.KeepaliveTimeout)
+
 		if err = c.write(&p); err != nil {
 			return err
 		}
 
-		c.lastKeepaliveId = id
+		atomic.StoreUint32(&c.lastKeepaliveId, id)
 
 		return nil
 	}
@@ -492,13 +937,13 @@ func (c *client) keepalive() {
 		case <-t.C:
 			if err := check(); err != nil {
 				c.Logger.Errorf("keepalive error: %v", err)
-				c.reconnecting()
+				c.reconnecting(newDisconnectError(DisconnectKeepaliveTimeout, err))
 				continue
 			}
 
 			if err := ping(); err != nil {
 				c.Logger.Errorf("keepalive failed to ping, err: %v", err)
-				c.reconnecting()
+				c.reconnecting(newDisconnectError(DisconnectWriteError, err))
 				continue
 			}
 		}
@@ -508,12 +953,23 @@ func (c *client) keepalive() {
 func (c *client) onPacket(packet *protocol.Packet, err error) {
 	if err != nil {
 		c.Logger.Errorf("conn receive packet error: %v", err)
-		c.reconnecting()
+		c.reconnecting(newDisconnectError(DisconnectReadError, err))
 		return
 	}
 
 	c.Logger.Debugf("got packet, type: %s, cmd: %d, req_id: %d, status_code: %d", packet.Metadata.Type, packet.CMD(), packet.Metadata.RequestId, packet.Metadata.StatusCode)
 
+	if packet.Metadata.Gzip {
+		c.acceptPeerCompression(packet.Metadata.Compression)
+	}
+
+	if c.verifier != nil && !c.isConnControl(packet) {
+		if err = c.verifier.Verify(packet); err != nil {
+			c.Logger.Warnf("drop packet failed to verify, type: %s, cmd: %d, req_id: %d, err: %v", packet.Metadata.Type, packet.CMD(), packet.Metadata.RequestId, err)
+			return
+		}
+	}
+
 	if packet.IsControl() {
 		c.handleControl(packet)
 		return
@@ -529,13 +985,53 @@ func (c *client) onPacket(packet *protocol.Packet, err error) {
 		return
 	}
 
-	c.Logger.Warnf("client did't support request now, cmd: %d", packet.Metadata.CmdCode)
+	if packet.Metadata.Type == protocol.RequestPacket {
+		// handle in another goroutine, so handler can do request to server
+		go c.handleRequest(packet)
+		return
+	}
+
+	c.Logger.Warnf("unknown packet type: %s, cmd: %d", packet.Metadata.Type, packet.Metadata.CmdCode)
+}
+
+func (c *client) handleRequest(packet *protocol.Packet) {
+	var (
+		body   proto.Message
+		status uint8
+	)
+
+	if h, ok := c.handlers[packet.CMD()]; ok {
+		body, status = h(packet)
+	} else {
+		// reply error, so server needn't wait until timeout
+		c.Logger.Warnf("no handler for request, cmd: %d, req_id: %d", packet.CMD(), packet.Metadata.RequestId)
+		body, status = &control.Error{Code: uint64(protocol.StatusBadRequest), Msg: "no handler for request"}, protocol.StatusBadRequest
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	res, err := protocol.NewResponse(c.conn.Context(), packet.CMD(), status, body, protocol.WithRequestId(packet.Metadata.RequestId))
+
+	if err != nil {
+		c.Logger.Errorf("failed to build response of req %d, err: %v", packet.Metadata.RequestId, err)
+		return
+	}
+
+	if err = c.write(&res); err != nil {
+		c.Logger.Errorf("failed to send response of req %d, err: %v", packet.Metadata.RequestId, err)
+	}
 }
 
 func (c *client) handlePush(packet *protocol.Packet) {
+	for _, sub := range c.subAll {
+		sub(packet)
+	}
+
 	subs, ok := c.subs[packet.CMD()]
 
 	if !ok || len(subs) == 0 {
+		c.handleUnhandledPush(packet)
 		return
 	}
 
@@ -544,6 +1040,24 @@ func (c *client) handlePush(packet *protocol.Packet) {
 	}
 }
 
+func (c *client) handleUnhandledPush(packet *protocol.Packet) {
+	c.unhandledMu.Lock()
+	c.unhandled[packet.CMD()]++
+	n := c.unhandled[packet.CMD()]
+	c.unhandledMu.Unlock()
+
+	// only warn at the first time to avoid flooding logs
+	if n == 1 {
+		c.Logger.Warnf("no subscriber for push, cmd: %d", packet.CMD())
+	} else {
+		c.Logger.Debugf("no subscriber for push, cmd: %d, count: %d", packet.CMD(), n)
+	}
+
+	if c.onUnhandledPush != nil {
+		c.onUnhandledPush(packet)
+	}
+}
+
 func (c *client) handleControl(packet *protocol.Packet) {
 	if packet.IsPing() {
 		c.handlePing(packet)
@@ -580,6 +1094,15 @@ func (c *client) handleResponse(packet *protocol.Packet) {
 	c.Logger.Warnf("no receiver for req %d", packet.Metadata.RequestId)
 }
 
+// isConnControl reports whether packet is made by conn from control frames, such as ping, pong and close of websocket
+func (c *client) isConnControl(packet *protocol.Packet) bool {
+	if c.conn.NeedHandleControl() {
+		return false
+	}
+
+	return packet.IsClose() || protocol.IsHeartbeat(packet.CMD())
+}
+
 func (c *client) handlePing(packet *protocol.Packet) {
 	if c.onPing != nil {
 		c.onPing(packet)
@@ -601,26 +1124,43 @@ func (c *client) handlePong(packet *protocol.Packet) {
 		c.onPong(packet)
 	}
 
-	c.lastPongAt = time.Now()
+	now := time.Now()
+	atomic.StoreInt64(&c.lastPongAt, now.UnixNano())
+
+	if rtt, ok := c.latency.pong(packet.Metadata.RequestId, now); ok {
+		c.Logger.Debugf("heartbeat %d rtt: %s", packet.Metadata.RequestId, rtt)
+	}
 }
 
-func (c *client) recv(ctx context.Context, rid uint32) (res *protocol.Packet, err error) {
-	ch := make(chan *protocol.Packet, 1)
+// addRecv adds receiver of response of request rid, release removes it
+func (c *client) addRecv(rid uint32) (ch chan *protocol.Packet, release func()) {
+	ch = make(chan *protocol.Packet, 1)
+
+	c.recvsMu.Lock()
+	c.recvs[rid] = ch
+	c.recvsMu.Unlock()
 
-	defer func() {
+	release = func() {
 		c.recvsMu.Lock()
-		delete(c.recvs, rid)
+		// receivers may be reset by reconnecting
+		if c.recvs[rid] == ch {
+			delete(c.recvs, rid)
+		}
 		c.recvsMu.Unlock()
+	}
 
-		close(ch)
-	}()
+	return
+}
 
-	c.recvsMu.Lock()
-	c.recvs[rid] = ch
-	c.recvsMu.Unlock()
+func (c *client) recv(ctx context.Context, rid uint32, ch chan *protocol.Packet) (res *protocol.Packet, err error) {
+	var ok bool
 
 	select {
-	case res = <-ch:
+	case res, ok = <-ch:
+		// closed by reconnecting
+		if !ok {
+			err = errConnClosed
+		}
 	case <-ctx.Done():
 		err = errors.Errorf("wait for %d response timeout", rid)
 	}
@@ -629,5 +1169,43 @@ func (c *client) recv(ctx context.Context, rid uint32) (res *protocol.Packet, er
 }
 
 func (c *client) write(p *protocol.Packet) error {
-	return c.conn.Write(p, protocol.GzipSize(c.dialOptions.MinGzipSize))
+	if c.dialOptions.Signer != nil {
+		protocol.SignPacket(c.conn.Context(), p, c.dialOptions.Signer)
+	}
+
+	t, level := c.compression()
+
+	return c.conn.Write(p, protocol.GzipSize(c.dialOptions.MinGzipSize), protocol.WithCompression(t, level))
+}
+
+// acceptPeerCompression records compression used by peer, peer is able to decompress body compressed by it
+func (c *client) acceptPeerCompression(t protocol.CompressionType) {
+	if t == protocol.CompressionNone {
+		return
+	}
+
+	for {
+		old := atomic.LoadUint32(&c.peerCompressions)
+
+		if old&(1<<t) != 0 || atomic.CompareAndSwapUint32(&c.peerCompressions, old, old|1<<t) {
+			return
+		}
+	}
+}
+
+// compression returns algorithm and level to compress body, gzip is used until peer is seen using the configured one
+func (c *client) compression() (protocol.CompressionType, int) {
+	t := c.dialOptions.Compression
+
+	if t == protocol.CompressionNone || t == protocol.CompressionGzip || atomic.LoadUint32(&c.peerCompressions)&(1<<t) != 0 {
+		return t, c.dialOptions.CompressionLevel
+	}
+
+	return protocol.CompressionGzip, protocol.CompressionLevelDefault
+}
+
+func (c *client) record(dir CaptureDirection, ctx *protocol.Context, frame []byte) {
+	if err := c.recorder.Record(dir, ctx, frame); err != nil {
+		c.Logger.Errorf("failed to record %s frame, len: %d, err: %v", dir, len(frame), err)
+	}
 }
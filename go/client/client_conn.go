@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"sync"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
 )
@@ -37,16 +38,29 @@ type ClientConn interface {
 	OnClose(cb func(error))
 }
 
+// flusher is implemented by conn which can wait for pending data written
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
 type closeCallback struct {
+	mu        sync.Mutex
 	callbacks []func(error)
 }
 
 func (c *closeCallback) OnClose(cb func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.callbacks = append(c.callbacks, cb)
 }
 
 func (c *closeCallback) DispatchClose(err error) {
-	for _, cb := range c.callbacks {
+	c.mu.Lock()
+	callbacks := c.callbacks
+	c.mu.Unlock()
+
+	for _, cb := range callbacks {
 		cb(err)
 	}
 }
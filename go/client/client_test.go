@@ -2,22 +2,39 @@ package client
 
 import (
 	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Allenxuxu/ringbuffer"
 	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/gorilla/websocket"
 	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
 )
 
 func init() {
 	RegisterDialer("mock", DialConnFunc(mockDialer))
+	RegisterDialer("failover", DialConnFunc(failoverDialer))
 }
 
 func mockDialer(ctx context.Context, _ protocol.Logger, uri *url.URL, handshake *protocol.Handshake, opts *DialOptions) (ClientConn, error) {
+	if uri.Hostname() == "unreachable" {
+		return nil, errors.New("mock unreachable")
+	}
+
 	qctx := protocol.NewContext(ctx, protocol.ClientSide)
 
 	qctx.Platform = handshake.Platform
@@ -27,68 +44,155 @@ func mockDialer(ctx context.Context, _ protocol.Logger, uri *url.URL, handshake
 	return &mockConn{
 		ctx:      qctx,
 		packetCh: make(chan *protocol.Packet, 1),
+		closeCh:  make(chan struct{}),
+		// server only supports version 1
+		rejected:    uri.Hostname() == "v1only" && handshake.Version > 1,
+		connControl: uri.Query().Get("control") == "conn",
 	}, nil
 }
 
+// failoverDialed keeps conns dialed by failoverDialer
+var failoverDialed []*mockConn
+
+// failoverDialer dials mock conn, host "authfail" accepts conn but rejects auth
+func failoverDialer(ctx context.Context, logger protocol.Logger, uri *url.URL, handshake *protocol.Handshake, opts *DialOptions) (ClientConn, error) {
+	conn, err := mockDialer(ctx, logger, uri, handshake, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mc := conn.(*mockConn)
+
+	if uri.Hostname() == "authfail" {
+		mc.setHooks(func(h *mockHooks) {
+			h.onAuth = func(p *protocol.Packet) *protocol.Packet {
+				rp := protocol.MustNewResponse(mc.ctx, uint32(control.Command_CMD_AUTH), protocol.StatusUnauthenticated, &control.Error{Msg: "invalid token"}, protocol.WithRequestId(p.Metadata.RequestId))
+				return &rp
+			}
+		})
+	}
+
+	failoverDialed = append(failoverDialed, mc)
+
+	return mc, nil
+}
+
 var _ ClientConn = &mockConn{}
 
 type mockConn struct {
 	ctx *protocol.Context
 
 	packetCh chan *protocol.Packet
+	closeCh  chan struct{}
 
+	// rejected and connControl are set by dialer before conn is used
+	rejected bool
+	// connControl makes conn handle control frames itself like websocket
+	connControl bool
+
+	mu     sync.Mutex
+	closed bool
+	hooks  mockHooks
+}
+
+// mockHooks answers packets written to mockConn, they are guarded by mu of conn
+type mockHooks struct {
 	onAuth      func(*protocol.Packet) *protocol.Packet
 	onReconnect func(*protocol.Packet) *protocol.Packet
 	authInfo    *control.AuthResponse
 	onPacket    func(*protocol.Packet) *protocol.Packet
 	onPush      func(*protocol.Packet)
-	writeError  error
+	onResponse  func(*protocol.Packet)
+	writeHook   func(*protocol.Packet, ...protocol.PackOption)
+}
 
-	closed bool
+// setHooks changes hooks of conn, conn may be written by client concurrently
+func (c *mockConn) setHooks(fn func(h *mockHooks)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn(&c.hooks)
 }
 
 func (c *mockConn) NeedHandleControl() bool {
-	return true
+	return !c.connControl
 }
 
 func (c *mockConn) Close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
 	c.closed = true
-	close(c.packetCh)
+	close(c.closeCh)
+}
+
+func (c *mockConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// send delivers packet to client, it is dropped if conn is closed
+func (c *mockConn) send(p *protocol.Packet) {
+	select {
+	case c.packetCh <- p:
+	case <-c.closeCh:
+	}
 }
 
 func (c *mockConn) OnClose(fn func(error)) {}
 
 func (c *mockConn) OnPacket(fn func(*protocol.Packet, error)) {
+	if c.rejected {
+		p := protocol.MustNewPush(c.ctx, uint32(control.Command_CMD_CLOSE), &control.Close{Code: control.Close_UnpackError, Reason: "unsupported version"})
+		c.packetCh <- &p
+	}
+
 	go func() {
-		for p := range c.packetCh {
-			fn(p, nil)
+		for {
+			select {
+			case p := <-c.packetCh:
+				fn(p, nil)
+			case <-c.closeCh:
+				return
+			}
 		}
 	}()
 
 }
 
 func (c *mockConn) Write(p *protocol.Packet, opts ...protocol.PackOption) error {
-	if c.closed {
+	c.mu.Lock()
+	closed, h := c.closed, c.hooks
+	c.mu.Unlock()
+
+	if closed {
 		return errConnClosed
 	}
 
+	if h.writeHook != nil {
+		h.writeHook(p, opts...)
+	}
+
 	if p.IsPing() {
 		rp := protocol.MustNewResponse(c.ctx, uint32(control.Command_CMD_HEARTBEAT), protocol.StatusSuccess, p.Body, protocol.WithRequestId(p.Metadata.RequestId))
 
-		go func() {
-			time.Sleep(time.Second)
-			c.packetCh <- &rp
-		}()
+		go c.send(&rp)
 
 		return nil
 	}
 
 	if p.IsAuth() {
 		rp := &protocol.Packet{}
-		if c.onAuth != nil {
-			rp = c.onAuth(p)
+		if h.onAuth != nil {
+			rp = h.onAuth(p)
 		} else {
-			info := c.authInfo
+			info := h.authInfo
 
 			if info == nil {
 				info = &control.AuthResponse{
@@ -100,21 +204,17 @@ func (c *mockConn) Write(p *protocol.Packet, opts ...protocol.PackOption) error
 			*rp = protocol.MustNewResponse(c.ctx, uint32(control.Command_CMD_AUTH), protocol.StatusSuccess, info, protocol.WithRequestId(p.Metadata.RequestId))
 		}
 
-		go func() {
-			time.Sleep(time.Second)
-			c.packetCh <- rp
-
-		}()
+		go c.send(rp)
 		return nil
 	}
 
 	if p.IsReconnect() {
 		rp := &protocol.Packet{}
 
-		if c.onReconnect != nil {
-			rp = c.onReconnect(p)
+		if h.onReconnect != nil {
+			rp = h.onReconnect(p)
 		} else {
-			info := c.authInfo
+			info := h.authInfo
 
 			if info == nil {
 				info = &control.AuthResponse{
@@ -126,10 +226,7 @@ func (c *mockConn) Write(p *protocol.Packet, opts ...protocol.PackOption) error
 			*rp = protocol.MustNewResponse(c.ctx, uint32(control.Command_CMD_RECONNECT), protocol.StatusSuccess, info, protocol.WithRequestId(p.Metadata.RequestId))
 		}
 
-		go func() {
-			time.Sleep(time.Second)
-			c.packetCh <- rp
-		}()
+		go c.send(rp)
 
 		return nil
 
@@ -139,18 +236,25 @@ func (c *mockConn) Write(p *protocol.Packet, opts ...protocol.PackOption) error
 		return nil
 	}
 
+	if p.Metadata.Type == protocol.ResponsePacket {
+		if h.onResponse != nil {
+			h.onResponse(p)
+		}
+
+		return nil
+	}
+
 	if p.Metadata.Type == protocol.PushPacket {
-		if c.onPush != nil {
-			c.onPush(p)
+		if h.onPush != nil {
+			h.onPush(p)
 		}
 
 		return nil
 	}
 
-	if c.onPacket != nil {
+	if h.onPacket != nil {
 		go func() {
-			time.Sleep(time.Second)
-			c.packetCh <- c.onPacket(p)
+			c.send(h.onPacket(p))
 		}()
 
 		return nil
@@ -160,10 +264,7 @@ func (c *mockConn) Write(p *protocol.Packet, opts ...protocol.PackOption) error
 	*rp = *p
 	rp.Metadata.Type = protocol.ResponsePacket
 
-	go func() {
-		time.Sleep(time.Second)
-		c.packetCh <- rp
-	}()
+	go c.send(rp)
 
 	return nil
 }
@@ -202,9 +303,11 @@ func TestClientAuth(t *testing.T) {
 		Expires:   time.Now().Add(time.Minute*2).UnixNano() / int64(time.Millisecond),
 	}
 
-	mc.authInfo = info
+	mc.setHooks(func(h *mockHooks) {
+		h.authInfo = info
+	})
 
-	err := cli.auth()
+	err := cli.auth(context.Background())
 	assert.Nil(t, err)
 
 	assert.Equal(t, info.SessionId, cli.authInfo.SessionId)
@@ -220,8 +323,9 @@ func TestClientReconnect(t *testing.T) {
 		Expires:   time.Now().Add(time.Minute*2).UnixNano() / int64(time.Millisecond),
 	}
 
-	err := cli.reconnect()
+	resumed, err := cli.reconnect()
 	assert.Nil(t, err)
+	assert.True(t, resumed)
 	assert.Equal(t, "session", cli.authInfo.SessionId)
 }
 
@@ -277,3 +381,867 @@ func TestClientOnPingPong(t *testing.T) {
 	<-waitCh
 	<-waitCh
 }
+
+func TestClientHandleRequest(t *testing.T) {
+	c, _ := newClientAndDial()
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	testCmd := uint32(112)
+
+	waitCh := make(chan *protocol.Packet, 1)
+
+	mc.setHooks(func(h *mockHooks) {
+		h.onResponse = func(p *protocol.Packet) {
+			waitCh <- p
+		}
+	})
+
+	cli.HandleRequest(testCmd, func(p *protocol.Packet) (proto.Message, uint8) {
+		assert.Equal(t, testCmd, p.CMD())
+		return &empty.Empty{}, protocol.StatusSuccess
+	})
+
+	req := protocol.MustNewRequest(mc.ctx, testCmd, &empty.Empty{})
+	mc.packetCh <- &req
+
+	res := <-waitCh
+	assert.Equal(t, protocol.ResponsePacket, res.Metadata.Type)
+	assert.Equal(t, req.Metadata.RequestId, res.Metadata.RequestId)
+	assert.Equal(t, protocol.StatusSuccess, res.StatusCode())
+
+	// request without handler is replied with error
+	req = protocol.MustNewRequest(mc.ctx, testCmd+1, &empty.Empty{})
+	mc.packetCh <- &req
+
+	res = <-waitCh
+	assert.Equal(t, req.Metadata.RequestId, res.Metadata.RequestId)
+	assert.Equal(t, protocol.StatusBadRequest, res.StatusCode())
+	assert.NotNil(t, res.Err())
+}
+
+func TestClientSubscribeConflated(t *testing.T) {
+	c, _ := newClientAndDial()
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	testCmd := uint32(113)
+
+	blockCh := make(chan struct{})
+	gotCh := make(chan string, 8)
+
+	cf := cli.SubscribeConflated(testCmd, func(p *protocol.Packet) string {
+		return p.GetMetadata("symbol")
+	}, func(p *protocol.Packet) {
+		<-blockCh
+		gotCh <- p.GetMetadata("seq")
+	})
+
+	push := func(symbol, seq string) {
+		p := protocol.MustNewPush(mc.ctx, testCmd, nil)
+		p.SetMetadataPairs(protocol.KVPair{Key: "symbol", Val: symbol}, protocol.KVPair{Key: "seq", Val: seq})
+		cf.Push(&p)
+	}
+
+	// first packet is taken by consumer and blocked
+	push("700.HK", "1")
+	time.Sleep(time.Millisecond * 100)
+
+	push("700.HK", "2")
+	push("AAPL.US", "3")
+	push("700.HK", "4")
+
+	close(blockCh)
+
+	assert.Equal(t, "1", <-gotCh)
+	assert.Equal(t, "4", <-gotCh)
+	assert.Equal(t, "3", <-gotCh)
+
+	stats := cf.Stats()
+	assert.Equal(t, uint64(4), stats.Received)
+	assert.Equal(t, uint64(3), stats.Delivered)
+	assert.Equal(t, uint64(1), stats.Conflated)
+}
+
+func TestClientUnhandledPush(t *testing.T) {
+	c, _ := newClientAndDial()
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	handledCmd := uint32(114)
+	unhandledCmd := uint32(115)
+
+	allCh := make(chan uint32, 4)
+	unhandledCh := make(chan uint32, 4)
+
+	cli.Subscribe(handledCmd, func(p *protocol.Packet) {})
+	cli.SubscribeAll(func(p *protocol.Packet) {
+		allCh <- p.CMD()
+	})
+	cli.OnUnhandledPush(func(p *protocol.Packet) {
+		unhandledCh <- p.CMD()
+	})
+
+	for _, cmd := range []uint32{handledCmd, unhandledCmd, unhandledCmd} {
+		p := protocol.MustNewPush(mc.ctx, cmd, nil)
+		mc.packetCh <- &p
+	}
+
+	assert.Equal(t, handledCmd, <-allCh)
+	assert.Equal(t, unhandledCmd, <-allCh)
+	assert.Equal(t, unhandledCmd, <-allCh)
+	assert.Equal(t, unhandledCmd, <-unhandledCh)
+	assert.Equal(t, unhandledCmd, <-unhandledCh)
+
+	assert.Equal(t, map[uint32]uint64{unhandledCmd: 2}, cli.UnhandledPushes())
+}
+
+func TestClientRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.cap")
+
+	r, err := NewRecorder(path)
+	assert.Nil(t, err)
+
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+	p, _ := protocol.GetProtocol(1)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	dopts := newDialOptions()
+	dopts.onFrame = func(dir CaptureDirection, ctx *protocol.Context, frame []byte) {
+		assert.Nil(t, r.Record(dir, ctx, frame))
+	}
+
+	hs := make([]byte, len(handshake.Pack()))
+	hsCh := make(chan error, 1)
+
+	go func() {
+		_, err := io.ReadFull(server, hs)
+		hsCh <- err
+	}()
+
+	conn, err := newTCPConn(context.Background(), &protocol.DefaultLogger{}, client, p, handshake, dopts)
+	assert.Nil(t, err)
+	assert.Nil(t, <-hsCh)
+
+	testCmd := uint32(116)
+
+	pktCh := make(chan *protocol.Packet, 3)
+	conn.OnPacket(func(p *protocol.Packet, err error) {
+		if err == nil {
+			pktCh <- p
+		}
+	})
+
+	// frames are written across reads, the last one is compressed
+	var frames [][]byte
+
+	for i := 0; i < 3; i++ {
+		push := protocol.MustNewPush(conn.Context(), testCmd, &control.Close{Reason: "push"})
+
+		var opts []protocol.PackOption
+		if i == 2 {
+			opts = append(opts, protocol.GzipSize(1))
+		}
+
+		b, err := p.Pack(conn.Context(), &push, opts...)
+		assert.Nil(t, err)
+
+		frames = append(frames, b)
+	}
+
+	go func() {
+		for _, b := range frames {
+			for len(b) > 0 {
+				n := 3
+				if n > len(b) {
+					n = len(b)
+				}
+
+				_, _ = server.Write(b[:n])
+				b = b[n:]
+			}
+		}
+	}()
+
+	for range frames {
+		select {
+		case <-pktCh:
+		case <-time.After(time.Second * 3):
+			t.Fatal("packet not received")
+		}
+	}
+
+	// outbound frame is captured as written to the wire
+	req := protocol.MustNewRequest(conn.Context(), testCmd, &control.Close{Reason: "request"})
+	assert.Nil(t, conn.Write(&req))
+
+	got := make([]byte, 64)
+	n, err := server.Read(got)
+	assert.Nil(t, err)
+
+	conn.Close(nil)
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	cr := NewCaptureReader(f)
+
+	for _, b := range frames {
+		rec, err := cr.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, CaptureInbound, rec.Direction)
+		assert.Equal(t, b, rec.Frame)
+	}
+
+	rec, err := cr.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, CaptureOutbound, rec.Direction)
+	assert.Equal(t, got[:n], rec.Frame)
+
+	_, err = cr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Nil(t, r.Close())
+
+	rc := New()
+
+	gotCh := make(chan string, 3)
+	rc.Subscribe(testCmd, func(p *protocol.Packet) {
+		var body control.Close
+		assert.Nil(t, p.Unmarshal(&body))
+		gotCh <- body.Reason
+	})
+
+	closeCh := make(chan error, 1)
+	rc.OnClose(func(err error) {
+		closeCh <- err
+	})
+
+	doneCh := make(chan error, 1)
+
+	err = rc.Dial(context.Background(), "replay://"+path, &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, ReplayDone(func(err error) {
+		doneCh <- err
+	}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, <-doneCh)
+	assert.Equal(t, 3, len(gotCh))
+	assert.Equal(t, "push", <-gotCh)
+
+	// client is closed at the end of capture
+	select {
+	case err := <-closeCh:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("client not closed after replay")
+	}
+	assert.Equal(t, StateClosed, rc.State())
+}
+
+func TestClientState(t *testing.T) {
+	c := New()
+
+	var states []State
+
+	c.OnStateChange(func(old, new State, reason string) {
+		states = append(states, new)
+	})
+
+	assert.Equal(t, StateIdle, c.State())
+
+	err := c.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, WithAuthTokenGetter(func() (string, error) {
+		return "token", nil
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, StateReady, c.State())
+
+	c.Close(nil)
+	assert.Equal(t, StateClosed, c.State())
+
+	assert.Equal(t, []State{StateDialing, StateHandshaking, StateAuthenticating, StateReady, StateClosed}, states)
+}
+
+func TestClientStateHandshaking(t *testing.T) {
+	handshakingCh := make(chan struct{})
+
+	// upgrade request carries handshake, it is answered only after handshaking state is observed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-handshakingCh:
+		case <-time.After(time.Second * 3):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	c := New()
+
+	var (
+		mu     sync.Mutex
+		states []State
+	)
+
+	c.OnStateChange(func(old, new State, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		states = append(states, new)
+
+		if new == StateHandshaking {
+			close(handshakingCh)
+		}
+	})
+
+	err := c.Dial(context.Background(), strings.Replace(ts.URL, "http://", "ws://", 1), &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	})
+	assert.Nil(t, err)
+
+	c.Close(nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, []State{StateDialing, StateHandshaking, StateReady, StateClosed}, states)
+}
+
+func TestClientDialFailover(t *testing.T) {
+	dial := func(u string, endpoints ...string) (*client, error) {
+		failoverDialed = nil
+
+		c := New()
+		err := c.Dial(context.Background(), u, &protocol.Handshake{
+			Platform: protocol.PlatformServer,
+			Codec:    protocol.CodecProtobuf,
+			Version:  1,
+		}, Endpoints(endpoints...), WithAuthTokenGetter(func() (string, error) {
+			return "token", nil
+		}))
+
+		return c.(*client), err
+	}
+
+	// primary endpoint is used, others are not dialed
+	cli, err := dial("failover://127.0.0.1", "failover://unreachable", "failover://127.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", cli.addr.Host)
+	assert.Len(t, failoverDialed, 1)
+	cli.Close(nil)
+
+	// failover for dial error
+	cli, err = dial("failover://unreachable", "failover://127.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.2", cli.addr.Host)
+	assert.Len(t, failoverDialed, 1)
+	cli.Close(nil)
+
+	// failover for auth error, conn failed to auth is closed
+	cli, err = dial("failover://authfail", "failover://127.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.2", cli.addr.Host)
+	assert.Equal(t, StateReady, cli.State())
+	assert.Len(t, failoverDialed, 2)
+
+	assert.True(t, failoverDialed[0].isClosed())
+
+	cli.Close(nil)
+}
+
+func TestClientSessionRefresh(t *testing.T) {
+	c := New()
+
+	waitCh := make(chan error, 1)
+
+	c.OnSessionRefresh(func(info *control.AuthResponse, err error) {
+		waitCh <- err
+	})
+
+	err := c.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, WithAuthTokenGetter(func() (string, error) {
+		return "token", nil
+	}), SessionRefresh(time.Minute*5-time.Second*2))
+	assert.Nil(t, err)
+
+	old := c.AuthInfo()
+
+	assert.Nil(t, <-waitCh)
+	assert.True(t, c.AuthInfo().Expires > old.Expires)
+	assert.Equal(t, StateReady, c.State())
+
+	c.Close(nil)
+
+	// session lives shorter than refresh-before, it is refreshed after min interval
+	info := &control.AuthResponse{Expires: time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)}
+	assert.Equal(t, minSessionRefreshInterval, sessionRefreshDelay(info, time.Hour))
+
+	d := sessionRefreshDelay(info, time.Second*30)
+	assert.True(t, d > time.Second*25 && d <= time.Second*30)
+}
+
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow(3)
+	now := time.Now()
+
+	for i, d := range []time.Duration{5, 1, 2, 3} {
+		w.ping(uint32(i), now, time.Minute)
+		rtt, ok := w.pong(uint32(i), now.Add(d*time.Millisecond))
+		assert.True(t, ok)
+		assert.Equal(t, d*time.Millisecond, rtt)
+	}
+
+	_, ok := w.pong(10, now)
+	assert.False(t, ok)
+
+	assert.Equal(t, LatencyStats{
+		Last:    time.Millisecond * 3,
+		Min:     time.Millisecond,
+		Avg:     time.Millisecond * 2,
+		P99:     time.Millisecond * 3,
+		Samples: 3,
+	}, w.stats())
+}
+
+func TestClientLatency(t *testing.T) {
+	c, _ := newClientAndDial(Keepalive(time.Millisecond * 20))
+	defer c.Close(nil)
+
+	assert.Eventually(t, func() bool {
+		return c.Latency().Samples > 0
+	}, time.Second*3, time.Millisecond*20)
+
+	assert.True(t, c.Latency().Last > 0)
+}
+
+func TestClientWaitReady(t *testing.T) {
+	c := New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, c.WaitReady(ctx))
+
+	readyCh := make(chan error, 1)
+
+	go func() {
+		readyCh <- c.WaitReady(context.Background())
+	}()
+
+	err := c.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, WithAuthTokenGetter(func() (string, error) {
+		return "token", nil
+	}))
+	assert.Nil(t, err)
+	assert.Nil(t, <-readyCh)
+
+	c.Close(nil)
+	assert.Equal(t, ErrClientClosed, c.WaitReady(context.Background()))
+}
+
+func TestClientDialCancel(t *testing.T) {
+	c := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.Dial(ctx, "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, WithAuthTokenGetter(func() (string, error) {
+		return "token", nil
+	}), Endpoints("mock://127.0.0.2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, StateIdle, c.State())
+
+	// conn failed to auth is closed, and next endpoint is not tried
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	assert.Equal(t, "127.0.0.1", cli.addr.Host)
+
+	assert.True(t, mc.isClosed())
+}
+
+func TestClientSessionStore(t *testing.T) {
+	store := NewFileSessionStore(filepath.Join(t.TempDir(), "session.json"))
+
+	dial := func() (Client, []string) {
+		c := New(WithSessionStore(store))
+
+		var reasons []string
+		c.OnStateChange(func(old, new State, reason string) {
+			reasons = append(reasons, reason)
+		})
+
+		err := c.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+			Platform: protocol.PlatformServer,
+			Codec:    protocol.CodecProtobuf,
+			Version:  1,
+		}, WithAuthTokenGetter(func() (string, error) {
+			return "token", nil
+		}))
+		assert.Nil(t, err)
+
+		return c, reasons
+	}
+
+	// no stored session, do auth
+	c, reasons := dial()
+	assert.Equal(t, "auth success", reasons[len(reasons)-1])
+	c.Close(nil)
+
+	info, err := store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "session", info.SessionId)
+
+	// resume stored session
+	c, reasons = dial()
+	assert.Equal(t, "session resumed", reasons[len(reasons)-1])
+	c.Close(nil)
+}
+
+func TestClientShutdown(t *testing.T) {
+	c, _ := newClientAndDial()
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	// request is answered after it is held for a while
+	writtenCh := make(chan struct{}, 1)
+	mc.setHooks(func(h *mockHooks) {
+		h.onPacket = func(p *protocol.Packet) *protocol.Packet {
+			writtenCh <- struct{}{}
+			<-time.After(time.Millisecond * 100)
+
+			rp := protocol.MustNewResponse(mc.ctx, p.CMD(), protocol.StatusSuccess, &control.Heartbeat{}, protocol.WithRequestId(p.Metadata.RequestId))
+			return &rp
+		}
+	})
+
+	var closed *control.Close
+	mc.setHooks(func(h *mockHooks) {
+		h.onPush = func(p *protocol.Packet) {
+			if p.CMD() == uint32(control.Command_CMD_CLOSE) {
+				closed = &control.Close{}
+				assert.Nil(t, p.Unmarshal(closed))
+			}
+		}
+	})
+
+	doneCh := make(chan error, 1)
+
+	go func() {
+		_, err := c.Do(context.Background(), &Request{Cmd: 100, Body: &control.Heartbeat{}})
+		doneCh <- err
+	}()
+
+	<-writtenCh
+
+	err := c.Shutdown(context.Background(), control.Close_ServerShutdown, "bye")
+	assert.Nil(t, err)
+
+	// in-flight request is finished before shutdown
+	select {
+	case err := <-doneCh:
+		assert.Nil(t, err)
+	default:
+		t.Fatal("in-flight request not finished")
+	}
+
+	assert.NotNil(t, closed)
+	assert.Equal(t, control.Close_ServerShutdown, closed.Code)
+	assert.Equal(t, "bye", closed.Reason)
+	assert.Equal(t, StateClosed, c.State())
+
+	_, err = c.Do(context.Background(), &Request{Cmd: 100, Body: &control.Heartbeat{}})
+	assert.Equal(t, ErrClientClosed, err)
+
+	// server is notified even if in-flight request is not drained in time
+	c, _ = newClientAndDial()
+	mc = c.(*client).conn.(*mockConn)
+
+	// request is not answered until test ends
+	releaseCh := make(chan struct{})
+	defer close(releaseCh)
+
+	writtenCh = make(chan struct{}, 1)
+	mc.setHooks(func(h *mockHooks) {
+		h.onPacket = func(p *protocol.Packet) *protocol.Packet {
+			writtenCh <- struct{}{}
+			<-releaseCh
+
+			rp := protocol.MustNewResponse(mc.ctx, p.CMD(), protocol.StatusSuccess, &control.Heartbeat{}, protocol.WithRequestId(p.Metadata.RequestId))
+			return &rp
+		}
+	})
+
+	closedCh := make(chan *control.Close, 1)
+	mc.setHooks(func(h *mockHooks) {
+		h.onPush = func(p *protocol.Packet) {
+			if p.CMD() == uint32(control.Command_CMD_CLOSE) {
+				var cl control.Close
+				assert.Nil(t, p.Unmarshal(&cl))
+				closedCh <- &cl
+			}
+		}
+	})
+
+	go func() {
+		_, _ = c.Do(context.Background(), &Request{Cmd: 100, Body: &control.Heartbeat{}})
+	}()
+
+	<-writtenCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	err = c.Shutdown(ctx, control.Close_ServerShutdown, "bye")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	select {
+	case cl := <-closedCh:
+		assert.Equal(t, "bye", cl.Reason)
+	default:
+		t.Fatal("close packet not sent")
+	}
+}
+
+func TestDisconnectCause(t *testing.T) {
+	cause, _ := disconnectCause(newDisconnectError(DisconnectWriteError, errors.Wrap(ErrWriteQueueFull, "len: 10")))
+	assert.Equal(t, DisconnectWriteQueueFull, cause)
+
+	cause, _ = disconnectCause(newDisconnectError(DisconnectConnClosed, newDisconnectError(DisconnectReadError, io.EOF)))
+	assert.Equal(t, DisconnectReadError, cause)
+
+	cause, code := disconnectCause(&disconnectError{cause: DisconnectServerClose, code: control.Close_SessExpired, err: io.EOF})
+	assert.Equal(t, DisconnectServerClose, cause)
+	assert.Equal(t, control.Close_SessExpired, code)
+
+	cause, _ = disconnectCause(io.EOF)
+	assert.Equal(t, DisconnectUnknown, cause)
+}
+
+func TestClientConnEvent(t *testing.T) {
+	c, _ := newClientAndDial(ReconnectBackoff(ConstantBackoff(time.Millisecond * 200)))
+	cli := c.(*client)
+	mc := cli.conn.(*mockConn)
+
+	var events []ConnEvent
+	c.OnConnEvent(func(ev ConnEvent) {
+		events = append(events, ev)
+	})
+
+	p := protocol.MustNewPush(mc.ctx, uint32(control.Command_CMD_CLOSE), &control.Close{Code: control.Close_ServerShutdown, Reason: "restart"})
+	cli.closeByServer(&p)
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, ConnEventDisconnected, events[0].Type)
+	assert.Equal(t, DisconnectServerClose, events[0].Cause)
+	assert.Equal(t, control.Close_ServerShutdown, events[0].CloseCode)
+
+	assert.Equal(t, ConnEventReconnected, events[1].Type)
+	assert.Equal(t, DisconnectServerClose, events[1].Cause)
+	assert.Equal(t, 1, events[1].Attempt)
+	assert.False(t, events[1].Resumed)
+	// the first attempt is delayed by backoff
+	assert.True(t, events[1].Outage >= time.Millisecond*200)
+}
+
+func TestClientSignature(t *testing.T) {
+	signer := protocol.NewHMACSigner([]byte("secret"))
+	serverVerifier := protocol.NewVerifier(signer, time.Minute)
+
+	c, err := newClientAndDial(WithSigner(signer), VerifySignature(time.Minute))
+	assert.Nil(t, err)
+	defer c.Close(nil)
+
+	mc := c.(*client).conn.(*mockConn)
+
+	sign := true
+
+	mc.setHooks(func(h *mockHooks) {
+		h.onPacket = func(p *protocol.Packet) *protocol.Packet {
+			assert.Nil(t, serverVerifier.Verify(p))
+
+			rp := protocol.MustNewResponse(mc.ctx, p.CMD(), protocol.StatusSuccess, &control.Heartbeat{}, protocol.WithRequestId(p.Metadata.RequestId))
+
+			if sign {
+				protocol.SignPacket(mc.ctx, &rp, signer)
+			}
+
+			return &rp
+		}
+	})
+
+	_, err = c.Do(context.Background(), &Request{Cmd: 100, Body: &control.Heartbeat{}})
+	assert.Nil(t, err)
+
+	// unsigned response is dropped
+	sign = false
+
+	_, err = c.Do(context.Background(), &Request{Cmd: 100, Body: &control.Heartbeat{}}, RequestTimeout(time.Millisecond*1500))
+	assert.NotNil(t, err)
+
+	// control packets made by conn are not signed
+	c = New()
+	err = c.Dial(context.Background(), "mock://127.0.0.1?control=conn", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, WithSigner(signer), VerifySignature(time.Minute))
+	assert.Nil(t, err)
+	defer c.Close(nil)
+
+	wc := c.(*client).conn.(*mockConn)
+
+	pongCh := make(chan struct{}, 1)
+	c.OnPong(func(p *protocol.Packet) {
+		pongCh <- struct{}{}
+	})
+
+	pong := protocol.MustNewResponse(wc.ctx, uint32(control.Command_CMD_HEARTBEAT), protocol.StatusSuccess, nil)
+	wc.packetCh <- &pong
+
+	select {
+	case <-pongCh:
+	case <-time.After(time.Second):
+		t.Fatal("unsigned pong of conn is dropped")
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+
+	ln, err := ListenInproc("compression")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	handshakeCh := make(chan protocol.Handshake, 1)
+	compressionCh := make(chan protocol.CompressionType, 2)
+
+	// server compresses responses by zstd, auth response is not compressed
+	go func() {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var h protocol.Handshake
+
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(conn, b); err != nil || h.Unpack(b) != nil {
+			return
+		}
+		handshakeCh <- h
+
+		p, _ := protocol.GetProtocol(h.Version)
+		ctx := protocol.NewContext(context.Background(), protocol.ServerSide)
+		ctx.Codec = h.Codec
+
+		buf := ringbuffer.New(4096)
+		rb := make([]byte, 4096)
+
+		for {
+			n, err := conn.Read(rb)
+
+			if err != nil {
+				return
+			}
+			_, _ = buf.Write(rb[:n])
+
+			for {
+				packet, done, err := p.Unpack(ctx, buf)
+
+				if err != nil || !done {
+					break
+				}
+
+				var data []byte
+
+				switch {
+				case packet.IsAuth():
+					rp := protocol.MustNewResponse(ctx, packet.CMD(), protocol.StatusSuccess, &control.AuthResponse{SessionId: "session", Expires: time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)}, protocol.WithRequestId(packet.Metadata.RequestId))
+					data, _ = p.Pack(ctx, &rp)
+				case packet.IsControl():
+					continue
+				default:
+					compressionCh <- packet.Metadata.Compression
+
+					rp := protocol.MustNewResponse(ctx, packet.CMD(), protocol.StatusSuccess, packet.Body, protocol.WithRequestId(packet.Metadata.RequestId))
+					data, _ = p.Pack(ctx, &rp, protocol.GzipSize(10), protocol.WithCompression(protocol.CompressionZstd, protocol.CompressionLevelDefault))
+				}
+
+				if _, err = conn.Write(data); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	c := New()
+	err = c.Dial(context.Background(), "inproc://compression", handshake, MinGzipSize(10), Compression(protocol.CompressionZstd, 3), AcceptCompressions(protocol.CompressionZstd))
+	assert.Nil(t, err)
+	defer c.Close(nil)
+
+	// server is told compressions accepted by client, handshake of caller is not changed
+	h := <-handshakeCh
+	assert.True(t, h.AcceptsCompression(protocol.CompressionZstd))
+	assert.False(t, h.AcceptsCompression(protocol.CompressionSnappy))
+	assert.Equal(t, uint8(0), handshake.Reserve)
+
+	// compressions are not advertised by default
+	dc, err := newClientAndDial()
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), dc.(*client).handshake.Reserve)
+	dc.Close(nil)
+
+	body := &control.Close{Reason: strings.Repeat("hello world", 10)}
+
+	// gzip is used until server is seen compressing by zstd
+	for _, want := range []protocol.CompressionType{protocol.CompressionGzip, protocol.CompressionZstd} {
+		res, err := c.Do(context.Background(), &Request{Cmd: 100, Body: body})
+		assert.Nil(t, err)
+		assert.Equal(t, want, <-compressionCh)
+
+		var got control.Close
+		assert.Nil(t, res.Unmarshal(&got))
+		assert.Equal(t, body.Reason, got.Reason)
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// ConflateKeyFunc returns conflation key of push packet,
+// packets with same key will be coalesced and only the latest one is delivered
+type ConflateKeyFunc func(*protocol.Packet) string
+
+// ConflateOption is func to set ConflateOptions
+type ConflateOption func(*ConflateOptions)
+
+// ConflateOptions are config for conflater
+type ConflateOptions struct {
+	// Interval is the min interval between two deliveries,
+	// zero means delivering whenever the consumer is ready
+	Interval time.Duration
+}
+
+// ConflateInterval set max delivery rate of conflater
+func ConflateInterval(d time.Duration) ConflateOption {
+	return func(o *ConflateOptions) {
+		if d > 0 {
+			o.Interval = d
+		}
+	}
+}
+
+// ConflateStats is counters of conflater
+type ConflateStats struct {
+	// Received is count of packets pushed to conflater
+	Received uint64
+	// Delivered is count of packets delivered to consumer
+	Delivered uint64
+	// Conflated is count of packets replaced by a newer one before delivered
+	Conflated uint64
+}
+
+// Conflater keeps the latest push packet per key and delivers them to consumer,
+// so bursts are coalesced instead of queued
+type Conflater struct {
+	// keep counters at the top for 64-bit atomic alignment
+	received  uint64
+	delivered uint64
+	conflated uint64
+
+	mu      sync.Mutex
+	keys    []string
+	pending map[string]*protocol.Packet
+
+	key  ConflateKeyFunc
+	sub  func(*protocol.Packet)
+	opts ConflateOptions
+
+	notifyCh  chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConflater returns a new conflater, sub will be invoked in a separate goroutine
+func NewConflater(key ConflateKeyFunc, sub func(*protocol.Packet), opts ...ConflateOption) *Conflater {
+	cf := &Conflater{
+		pending:  make(map[string]*protocol.Packet),
+		key:      key,
+		sub:      sub,
+		notifyCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(&cf.opts)
+	}
+
+	go cf.delivering()
+
+	return cf
+}
+
+// Push add packet to conflater, can be used as Subscribe handler
+func (cf *Conflater) Push(p *protocol.Packet) {
+	atomic.AddUint64(&cf.received, 1)
+
+	k := cf.key(p)
+
+	cf.mu.Lock()
+	if _, ok := cf.pending[k]; ok {
+		atomic.AddUint64(&cf.conflated, 1)
+	} else {
+		cf.keys = append(cf.keys, k)
+	}
+	cf.pending[k] = p
+	cf.mu.Unlock()
+
+	select {
+	case cf.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Stats return counters of conflater
+func (cf *Conflater) Stats() ConflateStats {
+	return ConflateStats{
+		Received:  atomic.LoadUint64(&cf.received),
+		Delivered: atomic.LoadUint64(&cf.delivered),
+		Conflated: atomic.LoadUint64(&cf.conflated),
+	}
+}
+
+// Close stop delivering, pending packets will be discarded
+func (cf *Conflater) Close() {
+	cf.closeOnce.Do(func() {
+		close(cf.closeCh)
+	})
+}
+
+func (cf *Conflater) delivering() {
+	var last time.Time
+
+	for {
+		select {
+		case <-cf.closeCh:
+			return
+		case <-cf.notifyCh:
+		}
+
+		if cf.opts.Interval > 0 {
+			if d := cf.opts.Interval - time.Since(last); d > 0 {
+				t := time.NewTimer(d)
+
+				select {
+				case <-cf.closeCh:
+					t.Stop()
+					return
+				case <-t.C:
+				}
+			}
+		}
+
+		cf.mu.Lock()
+		keys, pending := cf.keys, cf.pending
+		cf.keys = nil
+		cf.pending = make(map[string]*protocol.Packet, len(pending))
+		cf.mu.Unlock()
+
+		last = time.Now()
+
+		for _, k := range keys {
+			atomic.AddUint64(&cf.delivered, 1)
+			cf.sub(pending[k])
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FailoverStrategy decides the order of endpoints to dial
+type FailoverStrategy int
+
+const (
+	// FailoverOrdered always starts from the first endpoint
+	FailoverOrdered FailoverStrategy = iota
+	// FailoverRoundRobin starts from the endpoint next to the last used one
+	FailoverRoundRobin
+	// FailoverLowestLatency probes all endpoints and starts from the fastest one
+	FailoverLowestLatency
+)
+
+var failoverStrategyStrings = []string{"ordered", "round-robin", "lowest-latency"}
+
+func (s FailoverStrategy) String() string {
+	if s < 0 || int(s) >= len(failoverStrategyStrings) {
+		return "unknown"
+	}
+
+	return failoverStrategyStrings[int(s)]
+}
+
+// Resolver resolves endpoints to dial, it is invoked at dial and every reconnect
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// ResolverFunc is an adapter to allow the use of ordinary functions as Resolver
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+// Resolve implements Resolver
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// ProbeFunc measures latency of endpoint
+type ProbeFunc func(ctx context.Context, uri *url.URL) (time.Duration, error)
+
+// defaultPorts of schemes, port of tcp must be set explicitly
+var defaultPorts = map[string]string{
+	"ws":   "80",
+	"wss":  "443",
+	"tls":  "443",
+	"tcps": "443",
+}
+
+// hostPort returns address of endpoint, default port of scheme is used if port is absent
+func hostPort(uri *url.URL) string {
+	if uri.Port() == "" {
+		if port, ok := defaultPorts[uri.Scheme]; ok {
+			return net.JoinHostPort(uri.Hostname(), port)
+		}
+	}
+
+	return uri.Host
+}
+
+// probeTCP measures time of establishing tcp connection to endpoint
+func probeTCP(ctx context.Context, uri *url.URL) (time.Duration, error) {
+	var d net.Dialer
+
+	begin := time.Now()
+
+	conn, err := d.DialContext(ctx, "tcp", hostPort(uri))
+
+	if err != nil {
+		return 0, err
+	}
+
+	_ = conn.Close()
+
+	return time.Since(begin), nil
+}
+
+func parseEndpoints(addrs []string) ([]*url.URL, error) {
+	list := make([]*url.URL, 0, len(addrs))
+
+	for _, addr := range addrs {
+		uri, err := url.Parse(addr)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "parse dial url")
+		}
+
+		if _, ok := GetDialer(uri.Scheme); !ok {
+			return nil, errors.Errorf("dialer for scheme %s not exists", uri.Scheme)
+		}
+
+		list = append(list, uri)
+	}
+
+	return list, nil
+}
+
+// endpoints selects endpoint to dial
+type endpoints struct {
+	mu sync.Mutex
+
+	static []*url.URL
+	list   []*url.URL
+
+	idx      int
+	failures int
+	started  bool
+
+	strategy  FailoverStrategy
+	threshold int
+	resolver  Resolver
+	probe     ProbeFunc
+	timeout   time.Duration
+}
+
+func newEndpoints(u string, o *DialOptions) (*endpoints, error) {
+	static, err := parseEndpoints(append([]string{u}, o.Endpoints...))
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &endpoints{
+		static:    static,
+		list:      static,
+		strategy:  o.FailoverStrategy,
+		threshold: o.FailoverThreshold,
+		resolver:  o.Resolver,
+		probe:     o.EndpointProbe,
+		timeout:   o.Timeout,
+	}
+
+	if e.probe == nil {
+		e.probe = probeTCP
+	}
+
+	return e, nil
+}
+
+// reset is invoked at the begin of dialing or reconnecting, it decides the endpoint to start with
+func (e *endpoints) reset(ctx context.Context) error {
+	list := e.static
+
+	if e.resolver != nil {
+		addrs, err := e.resolver.Resolve(ctx)
+
+		if err != nil {
+			return errors.Wrap(err, "resolve endpoints")
+		}
+
+		if list, err = parseEndpoints(addrs); err != nil {
+			return err
+		}
+
+		if len(list) == 0 {
+			return errors.New("no endpoint resolved")
+		}
+	}
+
+	if e.strategy == FailoverLowestLatency && len(list) > 1 {
+		list = e.sortByLatency(ctx, list)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.strategy == FailoverRoundRobin && e.started {
+		e.idx = (e.idx + 1) % len(list)
+	} else {
+		e.idx = 0
+	}
+
+	e.list = list
+	e.failures = 0
+	e.started = true
+
+	return nil
+}
+
+func (e *endpoints) sortByLatency(ctx context.Context, list []*url.URL) []*url.URL {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	type probed struct {
+		uri     *url.URL
+		latency time.Duration
+	}
+
+	results := make([]probed, len(list))
+
+	var wg sync.WaitGroup
+
+	for i, uri := range list {
+		wg.Add(1)
+
+		go func(i int, uri *url.URL) {
+			defer wg.Done()
+
+			d, err := e.probe(ctx, uri)
+
+			if err != nil {
+				// put unreachable endpoint at last
+				d = math.MaxInt64
+			}
+
+			results[i] = probed{uri: uri, latency: d}
+		}(i, uri)
+	}
+
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].latency < results[j].latency
+	})
+
+	sorted := make([]*url.URL, len(results))
+
+	for i, r := range results {
+		sorted[i] = r.uri
+	}
+
+	return sorted
+}
+
+func (e *endpoints) len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.list)
+}
+
+func (e *endpoints) current() *url.URL {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// copy it, dialer may modify the url
+	u := *e.list[e.idx]
+	return &u
+}
+
+// next moves to next endpoint
+func (e *endpoints) next() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.idx = (e.idx + 1) % len(e.list)
+	e.failures = 0
+}
+
+// fail counts failure of current endpoint, and moves to next one if hit threshold
+func (e *endpoints) fail() {
+	e.mu.Lock()
+	e.failures++
+	hit := e.failures >= e.threshold
+	e.mu.Unlock()
+
+	if hit {
+		e.next()
+	}
+}
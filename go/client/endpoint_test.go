@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestEndpoints(t *testing.T) {
+	latencies := map[string]time.Duration{
+		"a": time.Millisecond * 30,
+		"b": time.Millisecond * 10,
+		"c": time.Millisecond * 20,
+	}
+
+	newEps := func(opts ...DialOption) *endpoints {
+		opts = append(opts, Endpoints("mock://b", "mock://c"), WithEndpointProbe(func(ctx context.Context, uri *url.URL) (time.Duration, error) {
+			return latencies[uri.Host], nil
+		}))
+		eps, err := newEndpoints("mock://a", newDialOptions(opts...))
+		assert.Nil(t, err)
+		assert.Nil(t, eps.reset(context.Background()))
+		return eps
+	}
+
+	eps := newEps(FailoverThreshold(2))
+	assert.Equal(t, "a", eps.current().Host)
+	eps.fail()
+	assert.Equal(t, "a", eps.current().Host)
+	eps.fail()
+	assert.Equal(t, "b", eps.current().Host)
+	assert.Nil(t, eps.reset(context.Background()))
+	assert.Equal(t, "a", eps.current().Host)
+
+	eps = newEps(Failover(FailoverRoundRobin))
+	assert.Equal(t, "a", eps.current().Host)
+	assert.Nil(t, eps.reset(context.Background()))
+	assert.Equal(t, "b", eps.current().Host)
+
+	eps = newEps(Failover(FailoverLowestLatency), FailoverThreshold(1))
+	assert.Equal(t, "b", eps.current().Host)
+	eps.fail()
+	assert.Equal(t, "c", eps.current().Host)
+
+	_, err := newEndpoints("unknown://a", newDialOptions())
+	assert.NotNil(t, err)
+
+	for u, want := range map[string]string{
+		"tcp://a":      "a",
+		"tls://a":      "a:443",
+		"tcps://a":     "a:443",
+		"wss://a":      "a:443",
+		"tcp://a:2020": "a:2020",
+	} {
+		uri, _ := url.Parse(u)
+		assert.Equal(t, want, hostPort(uri), u)
+	}
+
+	// tcp without port fails without dialing
+	uri, _ := url.Parse("tcp://a")
+	_, err = dialTCPConn(context.Background(), &protocol.DefaultLogger{}, uri, &protocol.Handshake{Version: 1}, newDialOptions())
+	assert.Contains(t, err.Error(), "missing port")
+}
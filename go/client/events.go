@@ -0,0 +1,145 @@
+package client
+
+import (
+	"time"
+
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
+)
+
+// DisconnectCause is the reason why conn between server is broken
+type DisconnectCause int
+
+const (
+	// DisconnectUnknown means cause can't be detected
+	DisconnectUnknown DisconnectCause = iota
+	// DisconnectKeepaliveTimeout means no pong received in keepalive timeout
+	DisconnectKeepaliveTimeout
+	// DisconnectReadError means conn failed to read packet
+	DisconnectReadError
+	// DisconnectWriteError means conn failed to write packet
+	DisconnectWriteError
+	// DisconnectWriteQueueFull means write queue of conn is full
+	DisconnectWriteQueueFull
+	// DisconnectServerClose means server closed the conn, see ConnEvent.CloseCode
+	DisconnectServerClose
+	// DisconnectConnClosed means conn is closed by transport
+	DisconnectConnClosed
+	// DisconnectReadQueueFull means packets are read faster than handled, see BackpressureClose
+	DisconnectReadQueueFull
+)
+
+var disconnectCauseStrings = []string{"unknown", "keepalive timeout", "read error", "write error", "write queue full", "server close", "conn closed", "read queue full"}
+
+func (c DisconnectCause) String() string {
+	if c < 0 || int(c) >= len(disconnectCauseStrings) {
+		return "unknown"
+	}
+
+	return disconnectCauseStrings[int(c)]
+}
+
+// ConnEventType is type of ConnEvent
+type ConnEventType int
+
+const (
+	// ConnEventDisconnected is emitted when conn is broken and client starts reconnecting
+	ConnEventDisconnected ConnEventType = iota
+	// ConnEventReconnectFailed is emitted after every failed reconnect attempt
+	ConnEventReconnectFailed
+	// ConnEventReconnected is emitted when client is ready again
+	ConnEventReconnected
+)
+
+var connEventTypeStrings = []string{"disconnected", "reconnect failed", "reconnected"}
+
+func (t ConnEventType) String() string {
+	if t < 0 || int(t) >= len(connEventTypeStrings) {
+		return "unknown"
+	}
+
+	return connEventTypeStrings[int(t)]
+}
+
+// ConnEvent describes disconnect and reconnect of client
+type ConnEvent struct {
+	Type ConnEventType
+	// Cause of disconnect, it is kept in all events of the same outage
+	Cause DisconnectCause
+	// CloseCode is the code sent by server, only valid when Cause is DisconnectServerClose
+	CloseCode control.Close_Code
+	// Err is the disconnect error for ConnEventDisconnected, or error of the failed attempt
+	Err error
+	// Endpoint is the endpoint of the disconnected conn or of the attempt
+	Endpoint string
+	// Attempt is number of reconnect attempt, starts from 1
+	Attempt int
+	// Backoff is the delay before next attempt, only valid for ConnEventReconnectFailed
+	Backoff time.Duration
+	// Resumed reports whether the session is resumed, false means full re-auth or no auth required
+	Resumed bool
+	// Outage is the duration since disconnected
+	Outage time.Duration
+}
+
+// disconnectError carries cause of disconnect to reconnecting
+type disconnectError struct {
+	cause DisconnectCause
+	code  control.Close_Code
+	err   error
+}
+
+func (e *disconnectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *disconnectError) Unwrap() error {
+	return e.err
+}
+
+func newDisconnectError(cause DisconnectCause, err error) error {
+	if errors.Is(err, ErrWriteQueueFull) {
+		cause = DisconnectWriteQueueFull
+	} else if errors.Is(err, ErrReadQueueFull) {
+		cause = DisconnectReadQueueFull
+	}
+
+	return &disconnectError{cause: cause, err: err}
+}
+
+// OnConnEvent using to handle disconnect and reconnect events of client
+func (c *client) OnConnEvent(fn func(ev ConnEvent)) {
+	c.onConnEvent = fn
+}
+
+func (c *client) emitConnEvent(ev ConnEvent) {
+	if c.onConnEvent != nil {
+		c.onConnEvent(ev)
+	}
+}
+
+// disconnectCause detects cause of disconnect from error
+func disconnectCause(err error) (DisconnectCause, control.Close_Code) {
+	var de *disconnectError
+
+	if errors.As(err, &de) {
+		// conn closed by a detected cause
+		if de.cause == DisconnectConnClosed {
+			if cause, code := disconnectCause(de.err); cause != DisconnectUnknown {
+				return cause, code
+			}
+		}
+
+		return de.cause, de.code
+	}
+
+	if errors.Is(err, ErrWriteQueueFull) {
+		return DisconnectWriteQueueFull, 0
+	}
+
+	if errors.Is(err, ErrReadQueueFull) {
+		return DisconnectReadQueueFull, 0
+	}
+
+	return DisconnectUnknown, 0
+}
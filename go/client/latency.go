@@ -0,0 +1,125 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyStats is statistics of heartbeat round-trip time in rolling window
+type LatencyStats struct {
+	Last    time.Duration
+	Min     time.Duration
+	Avg     time.Duration
+	P99     time.Duration
+	Samples int
+}
+
+// latencyWindow keeps rtt samples of recent heartbeats
+type latencyWindow struct {
+	mu sync.Mutex
+
+	samples []time.Duration
+	next    int
+	full    bool
+	last    time.Duration
+
+	// send time of heartbeats waiting for pong
+	pending map[uint32]time.Time
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{
+		samples: make([]time.Duration, size),
+		pending: make(map[uint32]time.Time),
+	}
+}
+
+// ping records send time of heartbeat
+func (w *latencyWindow) ping(id uint32, at time.Time, timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// drop heartbeats never ponged
+	for k, t := range w.pending {
+		if at.Sub(t) > timeout {
+			delete(w.pending, k)
+		}
+	}
+
+	w.pending[id] = at
+}
+
+// pong matches heartbeat by id and records rtt
+func (w *latencyWindow) pong(id uint32, at time.Time) (rtt time.Duration, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sent, ok := w.pending[id]
+
+	if !ok {
+		return
+	}
+
+	delete(w.pending, id)
+
+	rtt = at.Sub(sent)
+
+	w.last = rtt
+	w.samples[w.next] = rtt
+	w.next++
+
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+
+	return
+}
+
+// reset drops pending heartbeats, ids of heartbeat are restarted on new conn
+func (w *latencyWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = make(map[uint32]time.Time)
+}
+
+func (w *latencyWindow) stats() LatencyStats {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, w.samples[:n])
+	last := w.last
+	w.mu.Unlock()
+
+	st := LatencyStats{Last: last, Samples: n}
+
+	if n == 0 {
+		return st
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+
+	var sum time.Duration
+
+	for _, d := range samples {
+		sum += d
+	}
+
+	st.Min = samples[0]
+	st.Avg = sum / time.Duration(n)
+	st.P99 = samples[(n*99+99)/100-1]
+
+	return st
+}
+
+// Latency return statistics of heartbeat round-trip time
+func (c *client) Latency() LatencyStats {
+	return c.latency.stats()
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func init() {
+	RegisterDialer("unix", DialConnFunc(dialUnixConn))
+	RegisterDialer("inproc", DialConnFunc(dialInprocConn))
+}
+
+// dialUnixConn dials unix domain socket, framing is the same as tcp conn,
+// path of socket is taken from unix:///path/to/sock or unix:relative.sock
+func dialUnixConn(ctx context.Context, logger protocol.Logger, uri *url.URL, handshake *protocol.Handshake, o *DialOptions) (ClientConn, error) {
+	p, err := protocol.GetProtocol(handshake.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path := uri.Opaque
+
+	if path == "" {
+		path = uri.Host + uri.Path
+	}
+
+	dial := o.NetDialer
+
+	if dial == nil {
+		d := &net.Dialer{Timeout: o.Timeout}
+		dial = d.DialContext
+	}
+
+	conn, err := dial(ctx, "unix", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTCPConn(ctx, logger, conn, p, handshake, o)
+}
+
+var (
+	inprocMu        sync.Mutex
+	inprocListeners = make(map[string]*InprocListener)
+)
+
+// InprocListener accepts conns dialed by inproc://name in the same process
+type InprocListener struct {
+	name    string
+	connCh  chan net.Conn
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+// ListenInproc returns listener of name, server accepts conns of clients dialing inproc://name from it
+func ListenInproc(name string) (*InprocListener, error) {
+	inprocMu.Lock()
+	defer inprocMu.Unlock()
+
+	if _, ok := inprocListeners[name]; ok {
+		return nil, errors.Errorf("inproc listener %s is already exists", name)
+	}
+
+	ln := &InprocListener{
+		name:    name,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+
+	inprocListeners[name] = ln
+
+	return ln, nil
+}
+
+// Accept implements net.Listener
+func (ln *InprocListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.connCh:
+		return conn, nil
+	case <-ln.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener
+func (ln *InprocListener) Close() error {
+	ln.once.Do(func() {
+		inprocMu.Lock()
+		delete(inprocListeners, ln.name)
+		inprocMu.Unlock()
+
+		close(ln.closeCh)
+	})
+
+	return nil
+}
+
+// Addr implements net.Listener
+func (ln *InprocListener) Addr() net.Addr {
+	return inprocAddr(ln.name)
+}
+
+type inprocAddr string
+
+func (a inprocAddr) Network() string {
+	return "inproc"
+}
+
+func (a inprocAddr) String() string {
+	return string(a)
+}
+
+// dial returns client end of pipe after server end is accepted
+func (ln *InprocListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+
+	select {
+	case ln.connCh <- server:
+		return client, nil
+	case <-ln.closeCh:
+	case <-ctx.Done():
+	}
+
+	_ = client.Close()
+	_ = server.Close()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return nil, net.ErrClosed
+}
+
+// dialInprocConn dials InprocListener registered by ListenInproc, framing is the same as tcp conn
+func dialInprocConn(ctx context.Context, logger protocol.Logger, uri *url.URL, handshake *protocol.Handshake, o *DialOptions) (ClientConn, error) {
+	p, err := protocol.GetProtocol(handshake.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	name := uri.Opaque
+
+	if name == "" {
+		name = uri.Host + uri.Path
+	}
+
+	inprocMu.Lock()
+	ln, ok := inprocListeners[name]
+	inprocMu.Unlock()
+
+	if !ok {
+		return nil, errors.Errorf("inproc listener %s not exists", name)
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	conn, err := ln.dial(dctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTCPConn(ctx, logger, conn, p, handshake, o)
+}
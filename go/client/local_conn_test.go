@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestDialLocalConn(t *testing.T) {
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+
+	// accept one conn and read handshake from it
+	serve := func(ln net.Listener) chan []byte {
+		ch := make(chan []byte, 1)
+
+		go func() {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			b := make([]byte, len(handshake.Pack()))
+
+			if _, err = io.ReadFull(conn, b); err == nil {
+				ch <- b
+			}
+
+			// hold conn until client closes it
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+
+		return ch
+	}
+
+	sock := filepath.Join(t.TempDir(), "gw.sock")
+	unixLn, err := net.Listen("unix", sock)
+	assert.Nil(t, err)
+	defer unixLn.Close()
+
+	inprocLn, err := ListenInproc("gateway")
+	assert.Nil(t, err)
+	defer inprocLn.Close()
+
+	_, err = ListenInproc("gateway")
+	assert.NotNil(t, err)
+
+	for u, ln := range map[string]net.Listener{"unix://" + sock: unixLn, "inproc://gateway": inprocLn} {
+		gotCh := serve(ln)
+
+		uri, _ := url.Parse(u)
+		dialer, ok := GetDialer(uri.Scheme)
+		assert.True(t, ok)
+
+		conn, err := dialer(context.Background(), &protocol.DefaultLogger{}, uri, handshake, newDialOptions())
+		assert.Nil(t, err, u)
+		assert.Nil(t, conn.(flusher).Flush(context.Background()))
+		assert.Equal(t, handshake.Pack(), <-gotCh)
+
+		conn.Close(nil)
+	}
+
+	uri, _ := url.Parse("inproc://missing")
+	_, err = dialInprocConn(context.Background(), &protocol.DefaultLogger{}, uri, handshake, newDialOptions())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// negotiatedVersions caches accepted protocol version per endpoint
+var negotiatedVersions sync.Map
+
+const (
+	probePending = iota
+	probeAccepted
+	probeRejected
+)
+
+// versionProbe detects whether protocol version of handshake is accepted by server,
+// server rejects it by closing conn or sending CMD_CLOSE
+type versionProbe struct {
+	// detached is set once version is accepted, packets and close are passed to client directly
+	detached int32
+
+	mu     sync.Mutex
+	id     uint32
+	state  int
+	err    error
+	doneCh chan struct{}
+}
+
+func newVersionProbe() *versionProbe {
+	return &versionProbe{doneCh: make(chan struct{})}
+}
+
+// resolve must be invoked with mu locked
+func (p *versionProbe) resolve(err error) {
+	if p.state != probePending {
+		return
+	}
+
+	if err == nil {
+		p.state = probeAccepted
+		atomic.StoreInt32(&p.detached, 1)
+	} else {
+		p.state, p.err = probeRejected, err
+	}
+
+	close(p.doneCh)
+}
+
+// wrapPacket returns packet handler passing packets to probe before it is detached
+func (p *versionProbe) wrapPacket(fn func(*protocol.Packet, error)) func(*protocol.Packet, error) {
+	return func(packet *protocol.Packet, err error) {
+		if atomic.LoadInt32(&p.detached) == 1 || !p.onPacket(packet, err) {
+			fn(packet, err)
+		}
+	}
+}
+
+// wrapClose returns close handler passing close of conn to probe before it is detached
+func (p *versionProbe) wrapClose(fn func(error)) func(error) {
+	return func(err error) {
+		if atomic.LoadInt32(&p.detached) == 1 || !p.onClose(err) {
+			fn(err)
+		}
+	}
+}
+
+// onPacket returns true if packet is consumed by probe
+func (p *versionProbe) onPacket(packet *protocol.Packet, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.state {
+	case probeAccepted:
+		return false
+	case probeRejected:
+		// conn is abandoned
+		return true
+	}
+
+	switch {
+	case err != nil:
+		p.resolve(err)
+	case packet.IsClose():
+		var reason control.Close
+		_ = packet.Unmarshal(&reason)
+		p.resolve(errors.Errorf("close by server, code: %v, reason: %s", reason.Code, reason.Reason))
+	case packet.IsPong() && packet.Metadata.RequestId == p.id:
+		p.resolve(nil)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// onClose returns true if close of conn is consumed by probe
+func (p *versionProbe) onClose(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == probeAccepted {
+		return false
+	}
+
+	if err == nil {
+		err = errConnClosed
+	}
+
+	p.resolve(errors.Wrap(err, "conn closed"))
+
+	return true
+}
+
+func (p *versionProbe) wait(ctx context.Context, timeout time.Duration) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var err error
+
+	select {
+	case <-p.doneCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-t.C:
+		err = errors.New("wait for heartbeat timeout")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.resolve(err)
+
+	return p.err
+}
+
+// defaultMaxNegotiateVersion is the highest version negotiated if versions are not set by NegotiateVersion
+const defaultMaxNegotiateVersion = 2
+
+// versionCandidates returns versions to try, cached version of endpoint is the first
+func (c *client) versionCandidates() []uint8 {
+	var vers []uint8
+
+	for _, v := range protocol.Versions() {
+		if c.negotiable(v) {
+			vers = append(vers, v)
+		}
+	}
+
+	v, ok := negotiatedVersions.Load(c.addr.String())
+
+	if !ok {
+		return vers
+	}
+
+	cached := v.(uint8)
+
+	if !c.negotiable(cached) {
+		return vers
+	}
+
+	list := []uint8{cached}
+
+	for _, ver := range vers {
+		if ver != cached {
+			list = append(list, ver)
+		}
+	}
+
+	return list
+}
+
+// negotiate dials with versions from highest to lowest until one is accepted by server
+func (c *client) negotiate(ctx context.Context, dialer DialConnFunc) (err error) {
+	endpoint := c.addr.String()
+
+	for _, v := range c.versionCandidates() {
+		probe := newVersionProbe()
+
+		if err = c.dialVersion(ctx, dialer, v, probe); err == nil {
+			if err = probe.wait(ctx, c.dialOptions.Timeout); err == nil {
+				negotiatedVersions.Store(endpoint, v)
+				c.setState(StateHandshaking, fmt.Sprintf("protocol version %d negotiated", v))
+				return nil
+			}
+
+			c.RLock()
+			c.conn.Close(errors.Wrap(err, "close rejected conn"))
+			c.RUnlock()
+		}
+
+		if cached, ok := negotiatedVersions.Load(endpoint); ok && cached.(uint8) == v {
+			negotiatedVersions.Delete(endpoint)
+		}
+
+		c.Logger.Warnf("protocol version %d is not accepted by %s, err: %v", v, endpoint, err)
+	}
+
+	return errors.Wrap(err, "negotiate protocol version")
+}
+
+// sendProbe sends heartbeat, which is answered only if version is accepted
+func (c *client) sendProbe(probe *versionProbe) error {
+	id := c.conn.Context().NextReqId()
+
+	hid := int32(id)
+
+	p, err := protocol.NewPacket(c.conn.Context(), protocol.RequestPacket, uint32(control.Command_CMD_HEARTBEAT), &control.Heartbeat{Timestamp: time.Now().UnixNano() / int64(time.Millisecond), HeartbeatId: &hid}, protocol.WithRequestId(id))
+
+	if err != nil {
+		return err
+	}
+
+	probe.mu.Lock()
+	probe.id = id
+	probe.mu.Unlock()
+
+	return c.write(&p)
+}
+
+// ProtocolVersion return protocol version of conn, it is the negotiated one if NegotiateVersion is set
+func (c *client) ProtocolVersion() uint8 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.version
+}
+
+// negotiable reports whether version v may be negotiated
+func (c *client) negotiable(v uint8) bool {
+	if len(c.dialOptions.NegotiateVersions) == 0 {
+		return v <= defaultMaxNegotiateVersion
+	}
+
+	for _, ver := range c.dialOptions.NegotiateVersions {
+		if ver == v {
+			return true
+		}
+	}
+
+	return false
+}
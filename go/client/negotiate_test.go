@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestClientNegotiateVersion(t *testing.T) {
+	dial := func(host string) Client {
+		c := New()
+
+		handshake := &protocol.Handshake{
+			Platform: protocol.PlatformServer,
+			Codec:    protocol.CodecProtobuf,
+			Version:  1,
+		}
+
+		err := c.Dial(context.Background(), "mock://"+host, handshake, NegotiateVersion())
+		assert.Nil(t, err)
+		// negotiated version is kept by client
+		assert.Equal(t, uint8(1), handshake.Version)
+
+		return c
+	}
+
+	c := dial("negotiate")
+	assert.Equal(t, uint8(2), c.ProtocolVersion())
+	c.Close(nil)
+
+	c = dial("v1only")
+	assert.Equal(t, uint8(1), c.ProtocolVersion())
+	assert.Equal(t, StateReady, c.State())
+
+	v, _ := negotiatedVersions.Load("mock://v1only")
+	assert.Equal(t, uint8(1), v)
+	assert.Equal(t, []uint8{1, 2}, c.(*client).versionCandidates())
+	c.Close(nil)
+
+	// version 3 is negotiated only if it is listed, cached version not listed is skipped
+	c = New()
+	err := c.Dial(context.Background(), "mock://negotiate", &protocol.Handshake{Platform: protocol.PlatformServer, Codec: protocol.CodecProtobuf, Version: 1}, NegotiateVersion(3, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), c.ProtocolVersion())
+	assert.Equal(t, []uint8{3, 1}, c.(*client).versionCandidates())
+	c.Close(nil)
+
+	// version 3 is registered by client
+	c = New()
+	err = c.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{Platform: protocol.PlatformServer, Codec: protocol.CodecProtobuf, Version: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), c.ProtocolVersion())
+	c.Close(nil)
+}
+
+func TestVersionProbeDetach(t *testing.T) {
+	probe := newVersionProbe()
+	probe.id = 7
+
+	var passed []*protocol.Packet
+	onPacket := probe.wrapPacket(func(p *protocol.Packet, err error) {
+		passed = append(passed, p)
+	})
+
+	ctx := protocol.NewContext(context.Background(), protocol.ClientSide)
+	pong := protocol.MustNewResponse(ctx, uint32(control.Command_CMD_HEARTBEAT), protocol.StatusSuccess, nil, protocol.WithRequestId(7))
+	onPacket(&pong, nil)
+	assert.Empty(t, passed)
+	assert.Nil(t, probe.wait(context.Background(), time.Second))
+
+	// probe is not consulted after it is detached
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+
+	push := protocol.MustNewPush(ctx, 1, nil)
+	onPacket(&push, nil)
+	assert.Equal(t, []*protocol.Packet{&push}, passed)
+}
@@ -2,34 +2,45 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
 	"time"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
 )
 
 var (
-	defaultDialTimeout      = time.Second * 5
-	defaultAuthTimeout      = time.Second * 10
-	defaultKeepalive        = time.Second * 60
-	defaultKeepaliveTimeout = defaultKeepalive * 2
-	defaultWriteQueueSize   = 16
-	defaultReadBufferSize   = 4096
-	defaultReadQueueSize    = 16
-	defaultMinGzipSize      = 1024
+	defaultDialTimeout       = time.Second * 5
+	defaultAuthTimeout       = time.Second * 10
+	defaultKeepalive         = time.Second * 60
+	defaultKeepaliveTimeout  = defaultKeepalive * 2
+	defaultWriteQueueSize    = 16
+	defaultReadBufferSize    = 4096
+	defaultReadQueueSize     = 16
+	defaultMinGzipSize       = 1024
+	defaultBackoffBase       = time.Second
+	defaultBackoffMax        = time.Second * 30
+	defaultFailoverThreshold = 3
+	defaultLatencyWindow     = 100
+	defaultWriteTimeout      = time.Second * 10
 
 	defaultRequestTimeout = time.Second * 10
 )
 
 func newDialOptions(opts ...DialOption) *DialOptions {
 	o := &DialOptions{
-		Timeout:          defaultDialTimeout,
-		AuthTimeout:      defaultAuthTimeout,
-		KeepaliveTimeout: defaultKeepaliveTimeout,
-		Keepalive:        defaultKeepalive,
-		ReadBufferSize:   defaultReadBufferSize,
-		ReadQueueSize:    defaultReadQueueSize,
-		WriteQueueSize:   defaultWriteQueueSize,
-		MinGzipSize:      defaultMinGzipSize,
+		Timeout:           defaultDialTimeout,
+		AuthTimeout:       defaultAuthTimeout,
+		KeepaliveTimeout:  defaultKeepaliveTimeout,
+		Keepalive:         defaultKeepalive,
+		ReadBufferSize:    defaultReadBufferSize,
+		ReadQueueSize:     defaultReadQueueSize,
+		WriteQueueSize:    defaultWriteQueueSize,
+		MinGzipSize:       defaultMinGzipSize,
+		ReconnectBackoff:  NewExponentialBackoff(defaultBackoffBase, defaultBackoffMax),
+		FailoverThreshold: defaultFailoverThreshold,
+		WriteTimeout:      defaultWriteTimeout,
 	}
 
 	for _, opt := range opts {
@@ -50,11 +61,62 @@ type DialOptions struct {
 	Keepalive        time.Duration
 	KeepaliveTimeout time.Duration
 	WriteQueueSize   int
-	ReadQueueSize    int
-	ReadBufferSize   int
-	MinGzipSize      int
-	MaxReconnect     int
-	ProxyFor         string
+	WriteTimeout     time.Duration
+	Backpressure     BackpressurePolicy
+	NegotiateVersion bool
+	// NegotiateVersions are versions to negotiate, registered versions up to 2 if it is empty
+	NegotiateVersions []uint8
+	ReadQueueSize     int
+	ReadBufferSize    int
+	MinGzipSize       int
+	// Compression and CompressionLevel are used to compress body longer than MinGzipSize, gzip by default
+	Compression      protocol.CompressionType
+	CompressionLevel int
+	// AcceptCompressions are advertised to server by Reserve of handshake, Reserve is untouched if it is empty
+	AcceptCompressions []protocol.CompressionType
+	MaxReconnect       int
+	ReconnectBackoff   Backoff
+	ProxyFor           string
+
+	// SessionRefreshBefore is how long before session expiry to refresh it, zero means disabled
+	SessionRefreshBefore time.Duration
+
+	// Endpoints are backup endpoints besides the url passed to Dial
+	Endpoints         []string
+	Resolver          Resolver
+	FailoverStrategy  FailoverStrategy
+	FailoverThreshold int
+	EndpointProbe     ProbeFunc
+
+	// ReplayOriginalSpeed and ReplayDone are only used by replay dialer
+	ReplayOriginalSpeed bool
+	ReplayDone          func(error)
+
+	// TLSConfig is used by tls, tcps and wss dialers
+	TLSConfig *tls.Config
+	// TLSPins are base64 encoded sha256 of SubjectPublicKeyInfo, one of them must be in verified chain, or be the leaf if verification is skipped
+	TLSPins []string
+
+	// WSHeader, WSSubprotocols and WSCompression are only used by ws and wss dialers
+	WSHeader       http.Header
+	WSSubprotocols []string
+	WSCompression  bool
+
+	// Proxy decides proxy of endpoint, ProxyFromEnvironment is used by default
+	Proxy ProxyFunc
+	// NetDialer dials conn to endpoint or proxy
+	NetDialer NetDialFunc
+
+	// Signer signs outgoing packets, VerifyWindow > 0 makes incoming packets verified by it as well
+	Signer       protocol.Signer
+	VerifyWindow time.Duration
+
+	// onDrop is set by client to count dropped packets
+	onDrop func(*protocol.Packet)
+	// onHandshake is set by client, conn invokes it when established and going to send handshake
+	onHandshake func()
+	// onFrame is set by client to capture frames read from or written to conn
+	onFrame func(CaptureDirection, *protocol.Context, []byte)
 }
 
 // ReadBufferSize set read buffer size, unit: KB
@@ -102,6 +164,50 @@ func Keepalive(d time.Duration) DialOption {
 	}
 }
 
+// WriteTimeout set timeout of writing data to conn, conn is closed if the peer is stuck
+func WriteTimeout(d time.Duration) DialOption {
+	return func(o *DialOptions) {
+		if d > 0 {
+			o.WriteTimeout = d
+		}
+	}
+}
+
+// NegotiateVersion makes client try registered protocol versions of vers from highest to lowest,
+// registered versions up to 2 are tried if vers is empty, so newer versions are opt-in.
+// Version of handshake is ignored, the accepted one is cached per endpoint
+func NegotiateVersion(vers ...uint8) DialOption {
+	return func(o *DialOptions) {
+		o.NegotiateVersion = true
+		o.NegotiateVersions = vers
+	}
+}
+
+// WithSigner set Signer to sign outgoing packets
+func WithSigner(s protocol.Signer) DialOption {
+	return func(o *DialOptions) {
+		o.Signer = s
+	}
+}
+
+// VerifySignature makes incoming packets verified by Signer, packets unsigned, tampered
+// or with nonce replayed inside window are dropped
+func VerifySignature(window time.Duration) DialOption {
+	return func(o *DialOptions) {
+		if window > 0 {
+			o.VerifyWindow = window
+		}
+	}
+}
+
+// Backpressure set policy when read packet queue is full
+// Default is BackpressureDropPush
+func Backpressure(p BackpressurePolicy) DialOption {
+	return func(o *DialOptions) {
+		o.Backpressure = p
+	}
+}
+
 // Keepalive set hearbeat timeout
 func KeepaliveTimeout(d time.Duration) DialOption {
 	return func(o *DialOptions) {
@@ -129,6 +235,23 @@ func MinGzipSize(i int) DialOption {
 	}
 }
 
+// Compression set algorithm and level to compress body longer than MinGzipSize,
+// body is compressed by gzip until server is seen compressing by t
+func Compression(t protocol.CompressionType, level int) DialOption {
+	return func(o *DialOptions) {
+		o.Compression = t
+		o.CompressionLevel = level
+	}
+}
+
+// AcceptCompressions advertises compressions accepted by client to server by Reserve of handshake,
+// types not registered are skipped. Nothing is advertised by default
+func AcceptCompressions(types ...protocol.CompressionType) DialOption {
+	return func(o *DialOptions) {
+		o.AcceptCompressions = types
+	}
+}
+
 // MaxReconnect set max reconnect count
 // Default is unlimited time until session expired
 func MaxReconnect(i int) DialOption {
@@ -139,6 +262,66 @@ func MaxReconnect(i int) DialOption {
 	}
 }
 
+// ReconnectBackoff set strategy of delay between reconnect attempts
+// Default is exponential backoff from 1s to 30s with full jitter
+func ReconnectBackoff(b Backoff) DialOption {
+	return func(o *DialOptions) {
+		if b != nil {
+			o.ReconnectBackoff = b
+		}
+	}
+}
+
+// Endpoints set backup endpoints besides the url passed to Dial,
+// schemes of endpoints can be different, e.g. fall back to wss when tcp is blocked
+func Endpoints(u ...string) DialOption {
+	return func(o *DialOptions) {
+		o.Endpoints = append(o.Endpoints, u...)
+	}
+}
+
+// WithResolver set Resolver of endpoints, static endpoints are ignored if resolver is set
+func WithResolver(r Resolver) DialOption {
+	return func(o *DialOptions) {
+		o.Resolver = r
+	}
+}
+
+// Failover set strategy of choosing endpoint
+// Default is FailoverOrdered
+func Failover(s FailoverStrategy) DialOption {
+	return func(o *DialOptions) {
+		o.FailoverStrategy = s
+	}
+}
+
+// FailoverThreshold set count of reconnect failures before moving to next endpoint
+func FailoverThreshold(i int) DialOption {
+	return func(o *DialOptions) {
+		if i > 0 {
+			o.FailoverThreshold = i
+		}
+	}
+}
+
+// WithEndpointProbe set latency probe used by FailoverLowestLatency
+// Default is measuring time of establishing tcp connection
+func WithEndpointProbe(fn ProbeFunc) DialOption {
+	return func(o *DialOptions) {
+		o.EndpointProbe = fn
+	}
+}
+
+// SessionRefresh enable refreshing session in background before it expires,
+// AuthTokenGetter is used to get a new token and the conn is kept
+func SessionRefresh(before time.Duration) DialOption {
+	return func(o *DialOptions) {
+		if before > 0 {
+			o.SessionRefreshBefore = before
+		}
+	}
+}
+
 // WithAuthTokenGetter set AuthToken getter
 func WithAuthTokenGetter(f func() (string, error)) DialOption {
 	return func(o *DialOptions) {
@@ -146,6 +329,112 @@ func WithAuthTokenGetter(f func() (string, error)) DialOption {
 	}
 }
 
+// ReplayOriginalSpeed set whether replay packets at original speed of capture,
+// default is replaying as fast as possible
+func ReplayOriginalSpeed(b bool) DialOption {
+	return func(o *DialOptions) {
+		o.ReplayOriginalSpeed = b
+	}
+}
+
+// ReplayDone set callback invoked after all captured packets are handled,
+// err is nil if reach the end of capture. Client is closed after it
+func ReplayDone(fn func(err error)) DialOption {
+	return func(o *DialOptions) {
+		o.ReplayDone = fn
+	}
+}
+
+// WithTLSConfig set tls config of tls, tcps and wss dialers
+func WithTLSConfig(cfg *tls.Config) DialOption {
+	return func(o *DialOptions) {
+		o.TLSConfig = cfg
+	}
+}
+
+// TLSClientCertificate set certificate presented to server
+func TLSClientCertificate(cert tls.Certificate) DialOption {
+	return func(o *DialOptions) {
+		if o.TLSConfig == nil {
+			o.TLSConfig = &tls.Config{}
+		} else {
+			o.TLSConfig = o.TLSConfig.Clone()
+		}
+
+		o.TLSConfig.Certificates = append(o.TLSConfig.Certificates, cert)
+	}
+}
+
+// TLSPins set pinned public keys of server, pin is base64 encoded sha256 of SubjectPublicKeyInfo,
+// it can be generated by:
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func TLSPins(pins ...string) DialOption {
+	return func(o *DialOptions) {
+		o.TLSPins = append(o.TLSPins, pins...)
+	}
+}
+
+// WSHeader add http header to websocket handshake request
+func WSHeader(key, value string) DialOption {
+	return func(o *DialOptions) {
+		if o.WSHeader == nil {
+			o.WSHeader = http.Header{}
+		}
+
+		o.WSHeader.Add(key, value)
+	}
+}
+
+// WSOrigin set Origin header of websocket handshake request
+func WSOrigin(origin string) DialOption {
+	return func(o *DialOptions) {
+		if o.WSHeader == nil {
+			o.WSHeader = http.Header{}
+		}
+
+		o.WSHeader.Set("Origin", origin)
+	}
+}
+
+// WSSubprotocols set subprotocols requested in websocket handshake
+func WSSubprotocols(protocols ...string) DialOption {
+	return func(o *DialOptions) {
+		o.WSSubprotocols = protocols
+	}
+}
+
+// WSCompression enable permessage-deflate compression of websocket
+func WSCompression(enable bool) DialOption {
+	return func(o *DialOptions) {
+		o.WSCompression = enable
+	}
+}
+
+// WithProxy set proxy of all endpoints, supported schemes are socks5, socks5h, http and https,
+// user info of url is used to authenticate, nil means dial directly
+func WithProxy(proxy *url.URL) DialOption {
+	return func(o *DialOptions) {
+		o.Proxy = func(*url.URL) (*url.URL, error) {
+			return proxy, nil
+		}
+	}
+}
+
+// WithProxyFunc set func deciding proxy of endpoint
+func WithProxyFunc(fn ProxyFunc) DialOption {
+	return func(o *DialOptions) {
+		o.Proxy = fn
+	}
+}
+
+// WithNetDialer set func dialing conn to endpoint or proxy
+func WithNetDialer(fn NetDialFunc) DialOption {
+	return func(o *DialOptions) {
+		o.NetDialer = fn
+	}
+}
+
 // RequestOption is func to set RequestOptions
 type RequestOption func(*RequestOptions)
 
@@ -198,3 +487,17 @@ func WithConnectMetadata(m map[string]string) ClientOption {
 		c.connectMetadata = m
 	}
 }
+
+// WithRecorder set Recorder of client, all frames sent and received by tcp or ws conn will be captured
+func WithRecorder(r *Recorder) ClientOption {
+	return func(c *client) {
+		c.recorder = r
+	}
+}
+
+// WithSessionStore set SessionStore of client, stored session will be resumed at dial
+func WithSessionStore(s SessionStore) ClientOption {
+	return func(c *client) {
+		c.sessionStore = s
+	}
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// Pool maintains multiple clients connected to the same gateway,
+// requests are spread across them and subscriptions are pinned to one of them
+type Pool struct {
+	members []*poolMember
+
+	mu      sync.RWMutex
+	pinned  int
+	dialed  bool
+	onRepin func(Client)
+
+	// err is returned by Dial if opts can not be shared by members
+	err error
+}
+
+type poolMember struct {
+	// keep it at the top for 64-bit atomic alignment
+	inflight int64
+
+	Client
+
+	mu            sync.Mutex
+	onStateChange func(old, new State, reason string)
+}
+
+// OnStateChange using to handle state change of member, it is invoked after pool handles it
+func (m *poolMember) OnStateChange(fn func(old, new State, reason string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onStateChange = fn
+}
+
+func (m *poolMember) stateChanged(old, new State, reason string) {
+	m.mu.Lock()
+	fn := m.onStateChange
+	m.mu.Unlock()
+
+	if fn != nil {
+		fn(old, new, reason)
+	}
+}
+
+// NewPool returns a pool of size clients, opts are applied to every client.
+// SessionStore is not supported, members would save and resume the same session
+func NewPool(size int, opts ...ClientOption) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &Pool{
+		members: make([]*poolMember, size),
+	}
+
+	for i := range p.members {
+		m := &poolMember{Client: New(opts...)}
+
+		if m.Client.(*client).sessionStore != nil {
+			p.err = errors.New("session store is not supported by pool")
+		}
+
+		idx := i
+		m.Client.OnStateChange(func(old, new State, reason string) {
+			p.onMemberStateChange(idx, old, new)
+			m.stateChanged(old, new, reason)
+		})
+
+		p.members[i] = m
+	}
+
+	return p
+}
+
+// Dial dials all clients of pool, pool is closed if any of them failed
+func (p *Pool) Dial(ctx context.Context, u string, handshake *protocol.Handshake, opts ...DialOption) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	errs := make([]error, len(p.members))
+
+	var wg sync.WaitGroup
+
+	for i, m := range p.members {
+		wg.Add(1)
+
+		go func(i int, m *poolMember) {
+			defer wg.Done()
+
+			errs[i] = m.Dial(ctx, u, handshake, opts...)
+		}(i, m)
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			p.Close(err)
+			return errors.Wrapf(err, "dial pool member %d", i)
+		}
+	}
+
+	p.mu.Lock()
+	p.dialed = true
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Do will do request by the client with least in-flight requests
+func (p *Pool) Do(ctx context.Context, req *Request, opts ...RequestOption) (*protocol.Packet, error) {
+	return p.do(p.pick(), ctx, req, opts...)
+}
+
+// DoPinned will do request by the pinned client, it should be used for subscription requests
+func (p *Pool) DoPinned(ctx context.Context, req *Request, opts ...RequestOption) (*protocol.Packet, error) {
+	p.mu.RLock()
+	m := p.members[p.pinned]
+	p.mu.RUnlock()
+
+	return p.do(m, ctx, req, opts...)
+}
+
+func (p *Pool) do(m *poolMember, ctx context.Context, req *Request, opts ...RequestOption) (*protocol.Packet, error) {
+	atomic.AddInt64(&m.inflight, 1)
+	defer atomic.AddInt64(&m.inflight, -1)
+
+	return m.Do(ctx, req, opts...)
+}
+
+// pick returns ready member with least in-flight requests
+func (p *Pool) pick() *poolMember {
+	var (
+		best      *poolMember
+		bestReady bool
+	)
+
+	for _, m := range p.members {
+		ready := m.State() == StateReady
+
+		switch {
+		case best == nil, ready && !bestReady:
+			best, bestReady = m, ready
+		case ready == bestReady && atomic.LoadInt64(&m.inflight) < atomic.LoadInt64(&best.inflight):
+			best = m
+		}
+	}
+
+	return best
+}
+
+// Subscribe using to register handle of push data,
+// only pushes from the pinned client are delivered to avoid duplication
+// concurrency unsafe, please sub at first time
+func (p *Pool) Subscribe(cmd uint32, sub func(*protocol.Packet)) {
+	for i, m := range p.members {
+		idx := i
+
+		m.Subscribe(cmd, func(packet *protocol.Packet) {
+			p.mu.RLock()
+			pinned := p.pinned == idx
+			p.mu.RUnlock()
+
+			if pinned {
+				sub(packet)
+			}
+		})
+	}
+}
+
+// Pinned returns the client which subscriptions are pinned to
+func (p *Pool) Pinned() Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.members[p.pinned]
+}
+
+// OnRepin using to handle change of pinned client,
+// subscription requests should be done again by the new pinned client
+func (p *Pool) OnRepin(fn func(c Client)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onRepin = fn
+}
+
+// Members returns all clients of pool
+func (p *Pool) Members() []Client {
+	clients := make([]Client, len(p.members))
+
+	for i, m := range p.members {
+		clients[i] = m
+	}
+
+	return clients
+}
+
+// Close closes all clients of pool
+func (p *Pool) Close(err error) error {
+	for _, m := range p.members {
+		if m.State() != StateClosed {
+			m.Close(err)
+		}
+	}
+
+	return nil
+}
+
+// onMemberStateChange moves subscriptions to another ready member when the pinned one is broken
+func (p *Pool) onMemberStateChange(idx int, old, new State) {
+	p.mu.Lock()
+
+	if !p.dialed {
+		p.mu.Unlock()
+		return
+	}
+
+	pinnedLost := idx == p.pinned && old == StateReady && new != StateReady
+	pinnedDown := idx != p.pinned && new == StateReady && p.members[p.pinned].State() != StateReady
+
+	if !pinnedLost && !pinnedDown {
+		p.mu.Unlock()
+		return
+	}
+
+	next := -1
+
+	if pinnedDown {
+		next = idx
+	} else {
+		for i, m := range p.members {
+			if i != idx && m.State() == StateReady {
+				next = i
+				break
+			}
+		}
+	}
+
+	if next < 0 {
+		p.mu.Unlock()
+		return
+	}
+
+	p.pinned = next
+	fn := p.onRepin
+	c := p.members[next]
+	p.mu.Unlock()
+
+	if fn != nil {
+		// don't block state change of member
+		go fn(c)
+	}
+}
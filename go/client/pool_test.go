@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(3)
+
+	err := p.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	})
+	assert.Nil(t, err)
+
+	// requests are spread across members
+	picked := make(map[*poolMember]bool)
+	for i := 0; i < 3; i++ {
+		m := p.pick()
+		picked[m] = true
+		atomic.AddInt64(&m.inflight, 1)
+	}
+	assert.Equal(t, 3, len(picked))
+
+	testCmd := uint32(117)
+	gotCh := make(chan struct{}, 3)
+
+	p.Subscribe(testCmd, func(*protocol.Packet) {
+		gotCh <- struct{}{}
+	})
+
+	// only push of pinned member is delivered
+	for _, c := range p.Members() {
+		mc := c.(*poolMember).Client.(*client).conn.(*mockConn)
+		push := protocol.MustNewPush(mc.ctx, testCmd, nil)
+		mc.packetCh <- &push
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 1, len(gotCh))
+
+	repinCh := make(chan Client, 1)
+	p.OnRepin(func(c Client) {
+		repinCh <- c
+	})
+
+	// state hook of caller is invoked after pool handles it
+	stateCh := make(chan State, 8)
+	pinned := p.Pinned()
+	pinned.OnStateChange(func(old, new State, reason string) {
+		stateCh <- new
+	})
+
+	pinned.Close(nil)
+	assert.Equal(t, StateClosed, <-stateCh)
+
+	// compare by identity, deep comparing live clients races with their goroutines
+	next := <-repinCh
+	assert.True(t, pinned != next)
+	assert.True(t, next == p.Pinned())
+
+	p.Close(nil)
+}
+
+func TestPoolSessionStore(t *testing.T) {
+	p := NewPool(2, WithSessionStore(NewFileSessionStore(filepath.Join(t.TempDir(), "session.json"))))
+
+	err := p.Dial(context.Background(), "mock://127.0.0.1", &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NetDialFunc dials network conn, it has the same signature as net.Dialer.DialContext
+type NetDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ProxyFunc returns proxy url for target endpoint, nil means dial directly
+type ProxyFunc func(target *url.URL) (*url.URL, error)
+
+var defaultProxyPorts = map[string]string{
+	"socks5":  "1080",
+	"socks5h": "1080",
+	"http":    "80",
+	"https":   "443",
+}
+
+// ProxyFromEnvironment returns proxy url from ALL_PROXY or HTTPS_PROXY (or the lowercase versions),
+// targets match NO_PROXY are dialed directly
+func ProxyFromEnvironment(target *url.URL) (*url.URL, error) {
+	raw := getEnvAny("ALL_PROXY", "all_proxy")
+
+	if raw == "" {
+		raw = getEnvAny("HTTPS_PROXY", "https_proxy")
+	}
+
+	if raw == "" || noProxy(target.Hostname(), getEnvAny("NO_PROXY", "no_proxy")) {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+
+	// proxy is set as host:port
+	if err != nil || u.Host == "" {
+		if u, err = url.Parse("http://" + raw); err != nil {
+			return nil, errors.Wrap(err, "parse proxy url")
+		}
+	}
+
+	return u, nil
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// noProxy reports whether host matches rules, rules are comma separated hosts, domain suffixes, IPs, CIDRs or *.
+// Like net/http, localhost and loopback addresses are never proxied
+func noProxy(host, rules string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+
+		if rule == "" {
+			continue
+		}
+
+		if rule == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(rule); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, _, err := net.SplitHostPort(rule); err == nil {
+			rule = h
+		}
+
+		if ruleIP := net.ParseIP(rule); ruleIP != nil {
+			if ip != nil && ruleIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		rule = strings.TrimPrefix(rule, ".")
+
+		if host == rule || strings.HasSuffix(host, "."+rule) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialTCP dials tcp conn to uri, through proxy if there is one
+func dialTCP(ctx context.Context, uri *url.URL, o *DialOptions) (net.Conn, error) {
+	dial := o.NetDialer
+
+	if dial == nil {
+		d := &net.Dialer{Timeout: o.Timeout}
+		dial = d.DialContext
+	}
+
+	proxyFn := o.Proxy
+
+	if proxyFn == nil {
+		proxyFn = ProxyFromEnvironment
+	}
+
+	proxy, err := proxyFn(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy == nil {
+		return dial(ctx, "tcp", hostPort(uri))
+	}
+
+	proxyAddr := proxy.Host
+
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), defaultProxyPorts[proxy.Scheme])
+	}
+
+	conn, err := dial(ctx, "tcp", proxyAddr)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "dial proxy")
+	}
+
+	// proxy handshake is bounded by dial timeout
+	deadline := time.Now().Add(o.Timeout)
+
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	_ = conn.SetDeadline(deadline)
+
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy, hostPort(uri))
+	case "https":
+		tc := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		conn = tc
+
+		if err = tc.Handshake(); err == nil {
+			conn, err = httpConnect(conn, proxy, hostPort(uri))
+		}
+	case "http":
+		conn, err = httpConnect(conn, proxy, hostPort(uri))
+	default:
+		err = errors.Errorf("proxy scheme %s is not supported", proxy.Scheme)
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "connect through proxy %s", proxy.Redacted())
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// socks5Connect does socks5 handshake described in RFC 1928 and RFC 1929,
+// target host is always resolved by proxy
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+
+	if err != nil {
+		return errors.Wrap(err, "invalid port")
+	}
+
+	methods := []byte{0x00}
+
+	if proxy.User != nil {
+		methods = []byte{0x02}
+	}
+
+	if _, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[0] != 0x05 {
+		return errors.Errorf("socks5 unexpected version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if err = socks5Auth(conn, proxy.User); err != nil {
+			return err
+		}
+	default:
+		return errors.New("socks5 no acceptable auth method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5 host name too long")
+		}
+
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	// ver, rep, rsv, atyp
+	head := make([]byte, 4)
+
+	if _, err = io.ReadFull(conn, head); err != nil {
+		return err
+	}
+
+	if head[1] != 0x00 {
+		return errors.Errorf("socks5 connect failed, code: %d", head[1])
+	}
+
+	var n int
+
+	switch head[3] {
+	case 0x01:
+		n = net.IPv4len
+	case 0x04:
+		n = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+
+		if _, err = io.ReadFull(conn, l); err != nil {
+			return err
+		}
+
+		n = int(l[0])
+	default:
+		return errors.Errorf("socks5 unexpected address type %d", head[3])
+	}
+
+	// skip bound address and port
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+
+	return err
+}
+
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+
+	if len(name) > 255 || len(pass) > 255 {
+		return errors.New("socks5 username or password too long")
+	}
+
+	req := []byte{0x01, byte(len(name))}
+	req = append(req, name...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[1] != 0x00 {
+		return errors.New("socks5 auth failed")
+	}
+
+	return nil
+}
+
+// httpConnect establishes tunnel by HTTP CONNECT method
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+
+	if proxy.User != nil {
+		pass, _ := proxy.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+
+	if err != nil {
+		return conn, err
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return conn, errors.Errorf("proxy responded %s", res.Status)
+	}
+
+	// server may send data right after tunnel established
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn reads data buffered by reader first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
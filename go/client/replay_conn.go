@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func init() {
+	RegisterDialer("replay", DialConnFunc(dialReplayConn))
+}
+
+var _ ClientConn = &replayConn{}
+
+// dialReplayConn open capture file and replay inbound packets of it,
+// url is like replay:///path/to/file
+func dialReplayConn(ctx context.Context, logger protocol.Logger, uri *url.URL, handshake *protocol.Handshake, o *DialOptions) (ClientConn, error) {
+	path := uri.Opaque
+
+	if path == "" {
+		path = uri.Host + uri.Path
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "open capture file")
+	}
+
+	qctx := protocol.NewContext(ctx, protocol.ClientSide)
+	qctx.Codec = handshake.Codec
+	qctx.Platform = handshake.Platform
+	qctx.Version = handshake.Version
+
+	return &replayConn{
+		logger:        logger,
+		qctx:          qctx,
+		f:             f,
+		dopts:         *o,
+		closeCh:       make(chan struct{}),
+		closeCallback: newCloseCallback(),
+	}, nil
+}
+
+// replayConn is a fake conn which replays packets from capture file
+type replayConn struct {
+	*closeCallback
+	closeOnce    sync.Once
+	onPacketOnce sync.Once
+
+	logger protocol.Logger
+	qctx   *protocol.Context
+	f      *os.File
+
+	closeCh chan struct{}
+
+	packetCh chan *protocol.Packet
+
+	// error raised when replaying, nil if reach the end of capture
+	replayErr error
+
+	dopts DialOptions
+}
+
+func (conn *replayConn) NeedHandleControl() bool {
+	return true
+}
+
+func (conn *replayConn) Context() *protocol.Context {
+	return conn.qctx
+}
+
+func (conn *replayConn) Write(p *protocol.Packet, popts ...protocol.PackOption) error {
+	if conn.closed() {
+		return errConnClosed
+	}
+
+	// answer ping, so keepalive of client won't fail
+	if p.IsPing() {
+		pong, err := protocol.NewResponse(conn.qctx, uint32(control.Command_CMD_HEARTBEAT), protocol.StatusSuccess, p.Body, protocol.WithRequestId(p.Metadata.RequestId))
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case conn.packetCh <- &pong:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (conn *replayConn) OnPacket(fn func(*protocol.Packet, error)) {
+	// OnPacket can only invoke once
+	conn.onPacketOnce.Do(func() {
+		conn.packetCh = make(chan *protocol.Packet, conn.dopts.ReadQueueSize)
+
+		go conn.replaying()
+
+		go func() {
+			for {
+				select {
+				case <-conn.closeCh:
+					return
+				case p := <-conn.packetCh:
+					// nil packet means replay is done, conn is closed with io.EOF at the end of capture
+					if p == nil {
+						if conn.dopts.ReplayDone != nil {
+							conn.dopts.ReplayDone(conn.replayErr)
+						}
+
+						err := conn.replayErr
+						if err == nil {
+							err = io.EOF
+						}
+
+						conn.Close(err)
+						return
+					}
+
+					fn(p, nil)
+				}
+			}
+		}()
+	})
+}
+
+func (conn *replayConn) Close(err error) {
+	closed := true
+
+	// Close can only invoke once
+	conn.closeOnce.Do(func() {
+		closed = false
+
+		conn.logger.Infof("close replay conn, err: %v", err)
+		close(conn.closeCh)
+
+		_ = conn.f.Close()
+	})
+
+	// dispatched outside closeOnce, callbacks may close conn again
+	if !closed {
+		conn.DispatchClose(err)
+	}
+}
+
+func (conn *replayConn) closed() bool {
+	select {
+	case <-conn.closeCh:
+		return true
+	default:
+	}
+	return false
+}
+
+func (conn *replayConn) replaying() {
+	r := NewCaptureReader(conn.f)
+
+	var (
+		first time.Time
+		begin = time.Now()
+		err   error
+	)
+
+	defer func() {
+		conn.replayErr = err
+
+		select {
+		case <-conn.closeCh:
+		case conn.packetCh <- nil:
+		}
+	}()
+
+	for {
+		var rec *CaptureRecord
+
+		if rec, err = r.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+
+		if rec.Direction != CaptureInbound {
+			continue
+		}
+
+		if conn.dopts.ReplayOriginalSpeed {
+			if first.IsZero() {
+				first = rec.Time
+			}
+
+			if d := rec.Time.Sub(first) - time.Since(begin); d > 0 {
+				t := time.NewTimer(d)
+
+				select {
+				case <-conn.closeCh:
+					t.Stop()
+					return
+				case <-t.C:
+				}
+			}
+		}
+
+		var p *protocol.Packet
+
+		if p, err = rec.Unpack(); err != nil {
+			return
+		}
+
+		select {
+		case <-conn.closeCh:
+			return
+		case conn.packetCh <- p:
+		}
+	}
+}
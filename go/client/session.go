@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
+)
+
+// OnSessionRefresh using to handle result of proactive session refresh
+func (c *client) OnSessionRefresh(fn func(info *control.AuthResponse, err error)) {
+	c.onSessionRefresh = fn
+}
+
+// minSessionRefreshInterval avoids refreshing in a tight loop when session lives shorter than SessionRefreshBefore
+const minSessionRefreshInterval = time.Second
+
+func expiresAt(info *control.AuthResponse) time.Time {
+	return time.Unix(info.GetExpires()/1000, info.GetExpires()%1000*int64(time.Millisecond))
+}
+
+// sessionRefreshDelay returns how long to wait before refreshing session
+func sessionRefreshDelay(info *control.AuthResponse, before time.Duration) time.Duration {
+	d := time.Until(expiresAt(info)) - before
+
+	if d < minSessionRefreshInterval {
+		d = minSessionRefreshInterval
+	}
+
+	return d
+}
+
+// refreshing re-authenticates in band before session expires, so the conn and subscriptions are kept
+func (c *client) refreshing() {
+	var failures int
+
+	for {
+		var d time.Duration
+
+		if failures > 0 {
+			d = c.dialOptions.ReconnectBackoff.Next(failures)
+		} else if info := c.AuthInfo(); info != nil {
+			d = sessionRefreshDelay(info, c.dialOptions.SessionRefreshBefore)
+		}
+
+		t := time.NewTimer(d)
+
+		select {
+		case <-c.closeCh:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		// session will be resumed or re-authed by reconnecting
+		if c.State() != StateReady {
+			failures++
+			continue
+		}
+
+		old := c.AuthInfo()
+		info, err := c.doAuth(c.Context)
+
+		if err == nil && old != nil && !expiresAt(info).After(expiresAt(old)) {
+			err = errors.New("session expiry is not extended")
+		}
+
+		if err == nil {
+			c.setAuthInfo(info)
+			failures = 0
+			c.Logger.Infof("session refreshed, expires at %s", expiresAt(info))
+		} else {
+			failures++
+			c.Logger.Errorf("failed to refresh session, err: %v", err)
+		}
+
+		if c.onSessionRefresh != nil {
+			c.onSessionRefresh(info, err)
+		}
+	}
+}
+
+// SessionStore persists session, so client can resume it after process restarts
+type SessionStore interface {
+	// Load returns stored session, nil if not exists
+	Load() (*control.AuthResponse, error)
+	// Save stores session
+	Save(info *control.AuthResponse) error
+}
+
+// FileSessionStore stores session in a json file
+type FileSessionStore struct {
+	path string
+}
+
+// NewFileSessionStore returns a SessionStore saving session to path
+func NewFileSessionStore(path string) *FileSessionStore {
+	return &FileSessionStore{path: path}
+}
+
+type storedSession struct {
+	SessionId string `json:"session_id"`
+	Expires   int64  `json:"expires"`
+}
+
+// Load implements SessionStore
+func (s *FileSessionStore) Load() (*control.AuthResponse, error) {
+	data, err := os.ReadFile(s.path)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read session file")
+	}
+
+	var sess storedSession
+
+	if err = json.Unmarshal(data, &sess); err != nil {
+		return nil, errors.Wrap(err, "unmarshal session file")
+	}
+
+	return &control.AuthResponse{SessionId: sess.SessionId, Expires: sess.Expires}, nil
+}
+
+// Save implements SessionStore
+func (s *FileSessionStore) Save(info *control.AuthResponse) error {
+	data, err := json.Marshal(storedSession{SessionId: info.GetSessionId(), Expires: info.GetExpires()})
+
+	if err != nil {
+		return err
+	}
+
+	// write to temp file and rename it, so a crash won't leave a broken file
+	tmp := s.path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "write session file")
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (c *client) setAuthInfo(info *control.AuthResponse) {
+	c.Lock()
+	c.authInfo = info
+	c.Unlock()
+
+	if c.sessionStore == nil {
+		return
+	}
+
+	if err := c.sessionStore.Save(info); err != nil {
+		c.Logger.Errorf("failed to save session, err: %v", err)
+	}
+}
+
+// resumeStoredSession try to resume session loaded from SessionStore
+func (c *client) resumeStoredSession(ctx context.Context) error {
+	info, err := c.sessionStore.Load()
+
+	if err != nil {
+		return err
+	}
+
+	if info == nil || info.GetSessionId() == "" {
+		return errors.New("no stored session")
+	}
+
+	c.Lock()
+	c.authInfo = info
+	c.Unlock()
+
+	if c.isAuthExpired() {
+		return ErrSessExpired
+	}
+
+	_, err = c.reconnectDial(ctx)
+
+	return err
+}
@@ -0,0 +1,94 @@
+package client
+
+import "context"
+
+// State is connection state of client
+type State int32
+
+const (
+	// StateIdle means client is not dialed yet
+	StateIdle State = iota
+	// StateDialing means client is connecting to server
+	StateDialing
+	// StateHandshaking means conn is established and handshake is being sent
+	StateHandshaking
+	// StateAuthenticating means client is doing auth or resuming session
+	StateAuthenticating
+	// StateReady means client is authenticated and usable
+	StateReady
+	// StateReconnecting means conn is broken and client is waiting to reconnect
+	StateReconnecting
+	// StateClosed means client is closed and can't be used anymore
+	StateClosed
+)
+
+var stateStrings = []string{"idle", "dialing", "handshaking", "authenticating", "ready", "reconnecting", "closed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateStrings) {
+		return "unknown"
+	}
+
+	return stateStrings[int(s)]
+}
+
+// State return current connection state of client
+func (c *client) State() State {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.state
+}
+
+// OnStateChange using to handle state change of client
+func (c *client) OnStateChange(fn func(old, new State, reason string)) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.onStateChange = fn
+}
+
+// WaitReady blocks until client is in ready state, or ctx is done, or client is closed
+func (c *client) WaitReady(ctx context.Context) error {
+	c.stateMu.Lock()
+	ch := c.readyCh
+	c.stateMu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-c.closeCh:
+		return ErrClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *client) setState(s State, reason string) {
+	c.stateMu.Lock()
+	old := c.state
+
+	// closed is the final state
+	if old == s || old == StateClosed {
+		c.stateMu.Unlock()
+		return
+	}
+
+	c.state = s
+
+	// wake up waiters of ready state
+	if s == StateReady {
+		close(c.readyCh)
+	} else if old == StateReady {
+		c.readyCh = make(chan struct{})
+	}
+
+	fn := c.onStateChange
+	c.stateMu.Unlock()
+
+	c.Logger.Debugf("client state changed from %s to %s, reason: %s", old, s, reason)
+
+	if fn != nil {
+		fn(old, s, reason)
+	}
+}
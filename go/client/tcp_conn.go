@@ -12,6 +12,7 @@ import (
 	protocol "github.com/longportapp/openapi-protocol/go"
 	_ "github.com/longportapp/openapi-protocol/go/v1"
 	_ "github.com/longportapp/openapi-protocol/go/v2"
+	_ "github.com/longportapp/openapi-protocol/go/v3"
 	"github.com/pkg/errors"
 )
 
@@ -36,12 +37,17 @@ func dialTCPConn(ctx context.Context, logger protocol.Logger, uri *url.URL, hand
 		return nil, err
 	}
 
-	conn, err := net.DialTimeout("tcp", uri.Host, o.Timeout)
+	conn, err := dialTCP(ctx, uri, o)
 
 	if err != nil {
 		return nil, err
 	}
 
+	return newTCPConn(ctx, logger, conn, p, handshake, o)
+}
+
+// newTCPConn starts communicating on established conn and sends handshake
+func newTCPConn(ctx context.Context, logger protocol.Logger, conn net.Conn, p protocol.Protocol, handshake *protocol.Handshake, o *DialOptions) (ClientConn, error) {
 	qctx := protocol.NewContext(ctx, protocol.ClientSide)
 	data := handshake.Pack()
 	qctx.Codec = handshake.Codec
@@ -53,9 +59,9 @@ func dialTCPConn(ctx context.Context, logger protocol.Logger, uri *url.URL, hand
 		qctx:          qctx,
 		p:             p,
 		conn:          conn,
-		readBuf:       ringbuffer.New(o.ReadBufferSize),
 		writeCh:       make(chan []byte, o.WriteQueueSize),
-		buf:           make([]byte, 0xfffff), // alloc 1m length for reading
+		flushCh:       make(chan chan struct{}),
+		queue:         newReadQueue(o.ReadQueueSize),
 		closeCallback: newCloseCallback(),
 	}
 
@@ -65,8 +71,12 @@ func dialTCPConn(ctx context.Context, logger protocol.Logger, uri *url.URL, hand
 
 	c.communicating()
 
+	if o.onHandshake != nil {
+		o.onHandshake()
+	}
+
 	// do handshake
-	if err = c.write(data); err != nil {
+	if err := c.write(data); err != nil {
 		defer c.Close(err)
 		return nil, err
 	}
@@ -89,13 +99,15 @@ type tcpConn struct {
 
 	needAuth bool
 
-	writeBuf *ringbuffer.RingBuffer
-	readBuf  *ringbuffer.RingBuffer
-	writeCh  chan []byte
+	writeCh chan []byte
+	flushCh chan chan struct{}
 
-	buf []byte
+	// pending keeps incomplete frame, it is only used by reading goroutine
+	pending *ringbuffer.RingBuffer
+	// frame keeps bytes of frame being unpacked for capturing, it is only used by reading goroutine
+	frame []byte
 
-	packetCh chan *protocol.Packet
+	queue *readQueue
 
 	dopts DialOptions
 }
@@ -119,10 +131,14 @@ func (conn *tcpConn) Write(p *protocol.Packet, popts ...protocol.PackOption) err
 		return err
 	}
 
+	if conn.dopts.onFrame != nil {
+		conn.dopts.onFrame(CaptureOutbound, conn.qctx, data)
+	}
+
 	return conn.write(data)
 }
 
-// TODO: change to sync write?
+// write queues data, it is written by writing goroutine
 func (conn *tcpConn) write(data []byte) error {
 	if conn.closed() {
 		return errConnClosed
@@ -134,37 +150,13 @@ func (conn *tcpConn) write(data []byte) error {
 	default:
 	}
 
-	return errors.Errorf("write queue full, len: %d", len(conn.writeCh))
+	return errors.Wrapf(ErrWriteQueueFull, "len: %d", len(conn.writeCh))
 }
 
 func (conn *tcpConn) OnPacket(fn func(*protocol.Packet, error)) {
 	// OnPacket can only invoke once
 	conn.onPacketOnce.Do(func() {
-		conn.packetCh = make(chan *protocol.Packet, conn.dopts.ReadQueueSize)
-
-		go func() {
-			defer close(conn.packetCh)
-
-			for {
-				if conn.closed() {
-					// consume all packet
-					if l := len(conn.packetCh); l > 0 {
-						for i := 0; i < l; i++ {
-							p := <-conn.packetCh
-							fn(p, nil)
-						}
-
-					}
-
-					fn(nil, errConnClosed)
-					return
-				}
-
-				p := <-conn.packetCh
-
-				fn(p, nil)
-			}
-		}()
+		conn.queue.consume(fn, conn.closeCh)
 	})
 }
 
@@ -176,7 +168,6 @@ func (conn *tcpConn) Close(err error) {
 	conn.closeOnce.Do(func() {
 		conn.logger.Errorf("close conn, err: %v", err)
 		close(conn.closeCh)
-		close(conn.writeCh)
 
 		_ = conn.conn.Close()
 
@@ -199,123 +190,248 @@ func (conn *tcpConn) closed() bool {
 }
 
 func (conn *tcpConn) reading() {
+	// chunk is the only buffer held by idle conn
+	chunk := protocol.GetBuffer(conn.dopts.ReadBufferSize)
+	defer protocol.PutBuffer(chunk)
+
+	// view unpacks data of chunk in place
+	view := ringbuffer.NewWithData(nil)
+
+	defer func() {
+		if conn.pending != nil {
+			ringbuffer.PutInPool(conn.pending)
+			conn.pending = nil
+		}
+	}()
+
 	for {
 		if conn.closed() {
 			return
 		}
 
-		n, err := conn.conn.Read(conn.buf)
+		n, err := conn.conn.Read(chunk)
 
 		if err != nil {
-			conn.Close(err)
+			conn.Close(newDisconnectError(DisconnectReadError, err))
 			return
 		}
 
-		conn.logger.Debug("got data")
-
 		if n == 0 {
 			continue
 		}
 
-		if conn.readBuf.Length() == 0 {
-			buffer := ringbuffer.NewWithData(conn.buf[:n])
+		if conn.pending == nil {
+			view.WithData(chunk[:n])
 
-			if err = conn.readPacket(buffer); err != nil {
-				conn.Close(err)
+			if err = conn.readPacket(view); err != nil {
+				conn.Close(newDisconnectError(DisconnectReadError, err))
 				return
-
 			}
 
-			if buffer.Length() > 0 {
-				first, _ := buffer.PeekAll()
-				conn.readBuf.Write(first)
-			}
-		} else {
-			conn.readBuf.Write(conn.buf[:n])
+			// keep the incomplete frame, pending buffer grows as needed
+			if view.Length() > 0 {
+				first, end := view.PeekAll()
 
-			if err = conn.readPacket(conn.readBuf); err != nil {
-				conn.Close(err)
-				return
+				conn.pending = ringbuffer.GetFromPool()
+				_, _ = conn.pending.Write(first)
+				_, _ = conn.pending.Write(end)
 			}
+
+			continue
+		}
+
+		_, _ = conn.pending.Write(chunk[:n])
+
+		if err = conn.readPacket(conn.pending); err != nil {
+			conn.Close(newDisconnectError(DisconnectReadError, err))
+			return
+		}
+
+		// release pending buffer when drained, so idle conn holds nothing else
+		if conn.pending.Length() == 0 {
+			ringbuffer.PutInPool(conn.pending)
+			conn.pending = nil
 		}
 	}
 }
 
 func (conn *tcpConn) readPacket(buf *ringbuffer.RingBuffer) error {
+	capturing := conn.dopts.onFrame != nil
+
 	for {
+		var first, end []byte
+		l := buf.Length()
+
+		if capturing {
+			first, end = buf.PeekAll()
+		}
+
 		packet, done, err := conn.p.Unpack(conn.qctx, buf)
 		if err != nil {
 			return err
 		}
 
+		if capturing {
+			conn.captureRead(first, end, l-buf.Length())
+		}
+
 		if !done {
 			break
 		}
 
-		conn.addPacket(packet)
+		if capturing {
+			conn.dopts.onFrame(CaptureInbound, conn.qctx, conn.frame)
+			conn.frame = conn.frame[:0]
+		}
+
+		if err = conn.addPacket(packet); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (conn *tcpConn) addPacket(p *protocol.Packet) {
-	select {
-	case conn.packetCh <- p:
-	default:
-		conn.logger.Warn("drop packet for channel full")
+// captureRead keeps n bytes consumed by unpacking, a frame may be consumed by several unpacking
+func (conn *tcpConn) captureRead(first, end []byte, n int) {
+	if n <= len(first) {
+		conn.frame = append(conn.frame, first[:n]...)
+		return
 	}
+
+	conn.frame = append(conn.frame, first...)
+	conn.frame = append(conn.frame, end[:n-len(first)]...)
+}
+
+func (conn *tcpConn) addPacket(p *protocol.Packet) error {
+	return conn.queue.deliver(p, &conn.dopts, conn.closeCh)
 }
 
 func (conn *tcpConn) writing() {
-	buf := ringbuffer.New(4096)
+	for {
+		select {
+		case b := <-conn.writeCh:
+			if err := conn.writeBatch(b); err != nil {
+				conn.Close(newDisconnectError(DisconnectWriteError, err))
+				return
+			}
+		case done := <-conn.flushCh:
+			for {
+				if err := conn.writeBatch(nil); err != nil {
+					conn.Close(newDisconnectError(DisconnectWriteError, err))
+					return
+				}
 
-	t := time.NewTicker(time.Microsecond * 500)
+				if len(conn.writeCh) == 0 {
+					break
+				}
+			}
 
-	for {
-		if conn.closed() {
+			close(done)
+		case <-conn.closeCh:
 			return
 		}
+	}
+}
+
+// maxWriteBatch is max count of frames written by one writev
+const maxWriteBatch = 64
+
+// writeBatch keeps frames of one writev, bufs is consumed by writing so frames are kept separately
+type writeBatch struct {
+	frames [][]byte
+	bufs   net.Buffers
+}
+
+var batchPool = sync.Pool{
+	New: func() interface{} {
+		return &writeBatch{
+			frames: make([][]byte, 0, maxWriteBatch),
+			bufs:   make(net.Buffers, 0, maxWriteBatch),
+		}
+	},
+}
+
+// writeBatch coalesces b and queued frames into one write, written frames are put back to buffer pools
+func (conn *tcpConn) writeBatch(b []byte) error {
+	wb := batchPool.Get().(*writeBatch)
+
+	defer func() {
+		// drop references of frames before putting back
+		for i := range wb.frames {
+			wb.frames[i] = nil
+		}
+		for i := range wb.bufs {
+			wb.bufs[i] = nil
+		}
+		wb.frames, wb.bufs = wb.frames[:0], wb.bufs[:0]
+		batchPool.Put(wb)
+	}()
+
+	if b != nil {
+		wb.frames = append(wb.frames, b)
+	}
 
+coalesce:
+	for len(wb.frames) < maxWriteBatch {
 		select {
-		case b, ok := <-conn.writeCh:
-			if !ok {
-				return
-			}
+		case b := <-conn.writeCh:
+			wb.frames = append(wb.frames, b)
+		default:
+			break coalesce
+		}
+	}
+
+	if len(wb.frames) == 0 {
+		return nil
+	}
 
-			if buf.Length() != 0 {
-				f, e := buf.PeekAll()
-				buf.Reset()
+	if conn.dopts.WriteTimeout > 0 {
+		if err := conn.conn.SetWriteDeadline(time.Now().Add(conn.dopts.WriteTimeout)); err != nil {
+			return err
+		}
+	}
 
-				nb := make([]byte, len(f)+len(e)+len(b))
+	// WriteTo consumes the slice, so write by a copy of it
+	wb.bufs = append(wb.bufs[:0], wb.frames...)
+	bufs := wb.bufs
 
-				copy(nb, f)
-				copy(nb[len(f):], e)
-				copy(nb[len(f)+len(e):], b)
-				b = nb
-			}
+	// writev is used if conn supports it, partial writes are handled by WriteTo
+	if _, err := bufs.WriteTo(conn.conn); err != nil {
+		return err
+	}
 
-			if n, err := conn.conn.Write(b); err != nil {
-				conn.Close(err)
-				return
-			} else if n < len(b) {
-				buf.Write(b[n:])
-			}
-		case <-t.C:
-			if !buf.IsEmpty() {
-				f, e := buf.PeekAll()
-				buf.Reset()
+	for _, frame := range wb.frames {
+		protocol.PutBuffer(frame)
+	}
 
-				b := make([]byte, len(f)+len(e))
-				copy(b, f)
-				copy(b[len(f):], e)
+	return nil
+}
 
-				if n, err := conn.conn.Write(b); err != nil {
-					conn.Close(err)
-					return
-				} else if n < len(b) {
-					buf.Write(b[n:])
-				}
-			}
+// Flush waits for all queued data written
+func (conn *tcpConn) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+
+	select {
+	case conn.flushCh <- done:
+	case <-conn.closeCh:
+		return errConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-conn.closeCh:
+		// conn may be closed right after flushed
+		select {
+		case <-done:
+			return nil
+		default:
 		}
+		return errConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestTCPConnWrite(t *testing.T) {
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+	p, _ := protocol.GetProtocol(1)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn, err := newTCPConn(context.Background(), &protocol.DefaultLogger{}, client, p, handshake, newDialOptions(WriteQueueSize(256)))
+	assert.Nil(t, err)
+	defer conn.Close(nil)
+
+	tc := conn.(*tcpConn)
+
+	var want []byte
+	want = append(want, handshake.Pack()...)
+
+	for i := 0; i < 200; i++ {
+		frame := []byte(strconv.Itoa(i))
+		want = append(want, frame...)
+		assert.Nil(t, tc.write(frame))
+	}
+
+	got := make([]byte, len(want))
+	readCh := make(chan error, 1)
+
+	go func() {
+		_, err := io.ReadFull(server, got)
+		readCh <- err
+	}()
+
+	assert.Nil(t, tc.Flush(context.Background()))
+	assert.Nil(t, <-readCh)
+	assert.Equal(t, want, got)
+}
+
+func TestTCPConnRead(t *testing.T) {
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+	p, _ := protocol.GetProtocol(1)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn, err := newTCPConn(context.Background(), &protocol.DefaultLogger{}, client, p, handshake, newDialOptions())
+	assert.Nil(t, err)
+	defer conn.Close(nil)
+
+	pktCh := make(chan *protocol.Packet, 3)
+	conn.OnPacket(func(p *protocol.Packet, err error) {
+		if err == nil {
+			pktCh <- p
+		}
+	})
+
+	go func() {
+		_, _ = io.Copy(io.Discard, server)
+	}()
+
+	// the large body is across many reads
+	bodies := [][]byte{[]byte("small"), make([]byte, 20000), []byte("tail")}
+
+	var data []byte
+
+	for i, body := range bodies {
+		body[0] = byte(i)
+
+		pkt := protocol.Packet{
+			Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 100},
+			Body:     body,
+		}
+
+		b, err := p.Pack(conn.Context(), &pkt)
+		assert.Nil(t, err)
+
+		data = append(data, b...)
+	}
+
+	go func() {
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+
+			_, _ = server.Write(data[:n])
+			data = data[n:]
+		}
+	}()
+
+	for _, body := range bodies {
+		select {
+		case pkt := <-pktCh:
+			assert.Equal(t, body, pkt.Body)
+			pkt.Release()
+		case <-time.After(time.Second * 3):
+			t.Fatal("packet not received")
+		}
+	}
+}
+
+func TestTCPConnWriteTimeout(t *testing.T) {
+	p, _ := protocol.GetProtocol(1)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	closedCh := make(chan error, 1)
+
+	// peer never reads, handshake can't be written
+	conn, err := newTCPConn(context.Background(), &protocol.DefaultLogger{}, client, p, &protocol.Handshake{Version: 1}, newDialOptions(WriteTimeout(time.Millisecond*100)))
+	assert.Nil(t, err)
+	conn.OnClose(func(err error) {
+		closedCh <- err
+	})
+
+	select {
+	case err := <-closedCh:
+		cause, _ := disconnectCause(err)
+		assert.Equal(t, DisconnectWriteError, cause)
+	case <-time.After(time.Second * 3):
+		t.Fatal("conn not closed by write timeout")
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/url"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	RegisterDialer("tls", DialConnFunc(dialTLSConn))
+	RegisterDialer("tcps", DialConnFunc(dialTLSConn))
+}
+
+// ErrTLSPinMismatch means none of certificates presented by server matches pins
+var ErrTLSPinMismatch = errors.New("tls public key pin mismatch")
+
+// dialTLSConn dials tcp conn wrapped with tls, framing is the same as tcp conn
+func dialTLSConn(ctx context.Context, logger protocol.Logger, uri *url.URL, handshake *protocol.Handshake, o *DialOptions) (ClientConn, error) {
+	p, err := protocol.GetProtocol(handshake.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := tlsConfig(uri, o)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := dialTCP(ctx, uri, o)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(raw, cfg)
+
+	hctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	if err = conn.HandshakeContext(hctx); err != nil {
+		_ = raw.Close()
+		return nil, errors.Wrap(err, "tls handshake")
+	}
+
+	return newTCPConn(ctx, logger, conn, p, handshake, o)
+}
+
+// tlsConfig returns tls config for uri, pins are verified after certificate verification
+func tlsConfig(uri *url.URL, o *DialOptions) (*tls.Config, error) {
+	var cfg *tls.Config
+
+	if o.TLSConfig != nil {
+		cfg = o.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = uri.Hostname()
+	}
+
+	if len(o.TLSPins) == 0 {
+		return cfg, nil
+	}
+
+	pins := make([][]byte, len(o.TLSPins))
+
+	for i, pin := range o.TLSPins {
+		b, err := base64.StdEncoding.DecodeString(pin)
+
+		if err != nil || len(b) != sha256.Size {
+			return nil, errors.Errorf("invalid tls pin %q", pin)
+		}
+
+		pins[i] = b
+	}
+
+	verify := cfg.VerifyConnection
+
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+
+		// only certificates of verified chains are trusted, without verification it is the leaf
+		var certs []*x509.Certificate
+
+		if cfg.InsecureSkipVerify {
+			if len(cs.PeerCertificates) > 0 {
+				certs = cs.PeerCertificates[:1]
+			}
+		} else {
+			for _, chain := range cs.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+		}
+
+		for _, cert := range certs {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+
+		return ErrTLSPinMismatch
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestDialTLSConn(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	handshake := &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}
+
+	gotCh := make(chan []byte, 2)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				b := make([]byte, len(handshake.Pack()))
+
+				if _, err := io.ReadFull(conn, b); err == nil {
+					gotCh <- b
+				}
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	uri, _ := url.Parse("tls://" + ln.Addr().String())
+
+	dial := func(opts ...DialOption) error {
+		opts = append([]DialOption{WithTLSConfig(&tls.Config{RootCAs: roots, ServerName: "example.com"})}, opts...)
+
+		conn, err := dialTLSConn(context.Background(), &protocol.DefaultLogger{}, uri, handshake, newDialOptions(opts...))
+
+		if err == nil {
+			// handshake is queued, flush it before closing
+			assert.Nil(t, conn.(flusher).Flush(context.Background()))
+			conn.Close(nil)
+		}
+
+		return err
+	}
+
+	assert.Nil(t, dial(TLSPins(pin)))
+	assert.Equal(t, handshake.Pack(), <-gotCh)
+
+	err = dial(TLSPins(base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))))
+	assert.True(t, errors.Is(err, ErrTLSPinMismatch))
+
+	assert.NotNil(t, dial(TLSPins("invalid")))
+}
+
+// newTestCert returns certificate signed by parent, it is self-signed if parent is nil
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return cert, key
+}
+
+func TestTLSPinVerifiedChain(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	leaf, leafKey := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	// stray is presented by server but not part of verified chain
+	stray, _ := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "stray"},
+	}, nil, nil)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw, stray.Raw}, PrivateKey: leafKey}},
+	})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	pin := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	uri, _ := url.Parse("tls://" + ln.Addr().String())
+
+	dial := func(cfg *tls.Config, cert *x509.Certificate) error {
+		conn, err := dialTLSConn(context.Background(), &protocol.DefaultLogger{}, uri, &protocol.Handshake{Version: 1}, newDialOptions(WithTLSConfig(cfg), TLSPins(pin(cert))))
+
+		if err == nil {
+			conn.Close(nil)
+		}
+
+		return err
+	}
+
+	verified := &tls.Config{RootCAs: roots, ServerName: "example.com"}
+
+	assert.Nil(t, dial(verified, ca))
+	assert.Nil(t, dial(verified, leaf))
+	assert.True(t, errors.Is(dial(verified, stray), ErrTLSPinMismatch))
+
+	insecure := &tls.Config{InsecureSkipVerify: true}
+
+	assert.Nil(t, dial(insecure, leaf))
+	assert.True(t, errors.Is(dial(insecure, stray), ErrTLSPinMismatch))
+	assert.True(t, errors.Is(dial(insecure, ca), ErrTLSPinMismatch))
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -33,19 +34,72 @@ func dialWSConn(ctx context.Context, logger protocol.Logger, uri *url.URL, hands
 		return nil, err
 	}
 
-	query := url.Values{}
+	codec, platform := handshake.Codec, handshake.Platform
+
+	if codec == protocol.CodecUnknown {
+		codec = protocol.CodecProtobuf
+	}
+
+	if platform == protocol.PlatformUnknow {
+		platform = protocol.PlatformOpenapi
+	}
+
+	// keep query params of caller
+	query := uri.Query()
 	query.Set("version", strconv.FormatUint(uint64(ver), 10))
-	query.Set("codec", "1")
-	query.Set("platform", "9")
+	query.Set("codec", strconv.FormatUint(uint64(codec), 10))
+	query.Set("platform", strconv.FormatUint(uint64(platform), 10))
+
+	// reserve advertises accepted compressions
+	if handshake.Reserve != 0 {
+		query.Set("reserve", strconv.FormatUint(uint64(handshake.Reserve), 10))
+	}
 
 	uri.RawQuery = query.Encode()
 
 	dialer := websocket.Dialer{
-		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: o.Timeout,
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  o.Timeout,
+		Subprotocols:      o.WSSubprotocols,
+		EnableCompression: o.WSCompression,
 	}
 
-	conn, _, err := dialer.DialContext(ctx, uri.String(), nil)
+	if o.Proxy != nil {
+		dialer.Proxy = func(r *http.Request) (*url.URL, error) {
+			return o.Proxy(r.URL)
+		}
+	}
+
+	if o.NetDialer != nil {
+		dialer.NetDialContext = o.NetDialer
+	}
+
+	// handshake is sent by upgrade request once conn is established
+	if o.onHandshake != nil {
+		netDial := dialer.NetDialContext
+
+		if netDial == nil {
+			netDial = (&net.Dialer{}).DialContext
+		}
+
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := netDial(ctx, network, addr)
+
+			if err == nil {
+				o.onHandshake()
+			}
+
+			return conn, err
+		}
+	}
+
+	if uri.Scheme == "wss" && (o.TLSConfig != nil || len(o.TLSPins) != 0) {
+		if dialer.TLSClientConfig, err = tlsConfig(uri, o); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, _, err := dialer.DialContext(ctx, uri.String(), o.WSHeader)
 
 	if err != nil {
 		return nil, err
@@ -62,6 +116,8 @@ func dialWSConn(ctx context.Context, logger protocol.Logger, uri *url.URL, hands
 		p:             p,
 		conn:          conn,
 		writeCh:       make(chan []byte, o.WriteQueueSize),
+		flushCh:       make(chan chan struct{}),
+		queue:         newReadQueue(o.ReadQueueSize),
 		dopts:         *o,
 		closeCh:       make(chan struct{}),
 		closeCallback: newCloseCallback(),
@@ -93,8 +149,9 @@ type wsConn struct {
 	closeCh chan struct{}
 
 	writeCh chan []byte
+	flushCh chan chan struct{}
 
-	packetCh chan *protocol.Packet
+	queue *readQueue
 
 	dopts DialOptions
 }
@@ -126,6 +183,10 @@ func (conn *wsConn) Write(p *protocol.Packet, popts ...protocol.PackOption) erro
 		return err
 	}
 
+	if conn.dopts.onFrame != nil {
+		conn.dopts.onFrame(CaptureOutbound, conn.qctx, data)
+	}
+
 	return conn.write(data)
 }
 
@@ -140,7 +201,16 @@ func (conn *wsConn) writeClose(p *protocol.Packet) error {
 	if conn.closed() {
 		return errConnClosed
 	}
-	return conn.conn.WriteControl(websocket.CloseMessage, p.Body, time.Now().Add(time.Second*3))
+
+	var reason control.Close
+
+	if err := p.Unmarshal(&reason); err != nil {
+		return err
+	}
+
+	msg := websocket.FormatCloseMessage(int(reason.Code), reason.Reason)
+
+	return conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second*3))
 }
 
 func (conn *wsConn) write(data []byte) error {
@@ -154,37 +224,13 @@ func (conn *wsConn) write(data []byte) error {
 	default:
 	}
 
-	return errors.Errorf("write queue full, len: %d", len(conn.writeCh))
+	return errors.Wrapf(ErrWriteQueueFull, "len: %d", len(conn.writeCh))
 }
 
 func (conn *wsConn) OnPacket(fn func(*protocol.Packet, error)) {
 	// OnPacket can only invoke once
 	conn.onPacketOnce.Do(func() {
-		conn.packetCh = make(chan *protocol.Packet, conn.dopts.ReadQueueSize)
-
-		go func() {
-			defer close(conn.packetCh)
-
-			for {
-				if conn.closed() {
-					// consume all packet
-					if l := len(conn.packetCh); l > 0 {
-						for i := 0; i < l; i++ {
-							p := <-conn.packetCh
-							fn(p, nil)
-						}
-
-					}
-
-					fn(nil, errConnClosed)
-					return
-				}
-
-				p := <-conn.packetCh
-
-				fn(p, nil)
-			}
-		}()
+		conn.queue.consume(fn, conn.closeCh)
 	})
 }
 
@@ -195,8 +241,8 @@ func (conn *wsConn) Close(err error) {
 	// Close can only invoke once
 	conn.closeOnce.Do(func() {
 		conn.logger.Errorf("close conn, err: %v", err)
+		// writeCh is not closed, writers may be sending to it
 		close(conn.closeCh)
-		close(conn.writeCh)
 
 		_ = conn.conn.Close()
 
@@ -223,8 +269,7 @@ func (conn *wsConn) onClose(code int, message string) error {
 		Code:   control.Close_Code(code),
 		Reason: message,
 	})
-	conn.addPacket(&p)
-	return nil
+	return conn.addPacket(&p)
 }
 
 func (conn *wsConn) onPing(data string) error {
@@ -235,8 +280,7 @@ func (conn *wsConn) onPing(data string) error {
 	conn.logger.Debug("success pong back")
 
 	p := protocol.MustNewRequest(conn.qctx, uint32(control.Command_CMD_HEARTBEAT), []byte(data))
-	conn.addPacket(&p)
-	return nil
+	return conn.addPacket(&p)
 }
 
 func (conn *wsConn) onPong(data string) error {
@@ -251,8 +295,7 @@ func (conn *wsConn) onPong(data string) error {
 		}
 	}
 
-	conn.addPacket(&p)
-	return nil
+	return conn.addPacket(&p)
 }
 
 func (conn *wsConn) reading() {
@@ -264,7 +307,13 @@ func (conn *wsConn) reading() {
 		t, r, err := conn.conn.NextReader()
 
 		if err != nil {
-			conn.Close(err)
+			var ce *websocket.CloseError
+
+			if errors.As(err, &ce) {
+				conn.Close(&disconnectError{cause: DisconnectServerClose, code: control.Close_Code(ce.Code), err: err})
+			} else {
+				conn.Close(newDisconnectError(DisconnectReadError, err))
+			}
 			return
 		}
 
@@ -273,49 +322,115 @@ func (conn *wsConn) reading() {
 		data, err := io.ReadAll(r)
 
 		if err != nil {
-			conn.Close(err)
+			conn.Close(newDisconnectError(DisconnectReadError, err))
 			return
 		}
 
 		switch t {
 		case websocket.BinaryMessage, websocket.TextMessage:
-			conn.readPacket(data)
+			if err = conn.readPacket(data); err != nil {
+				if errors.Is(err, ErrReadQueueFull) {
+					conn.Close(newDisconnectError(DisconnectReadError, err))
+					return
+				}
+
+				conn.logger.Errorf("failed to read packet, err: %v", err)
+			}
 		}
 
 	}
 }
 
 func (conn *wsConn) readPacket(data []byte) error {
+	if conn.dopts.onFrame != nil {
+		conn.dopts.onFrame(CaptureInbound, conn.qctx, data)
+	}
+
 	packet, err := conn.p.UnpackBytes(conn.qctx, data)
 	if err != nil {
 		return err
 	}
-	conn.addPacket(packet)
-	return nil
+	return conn.addPacket(packet)
 }
 
-func (conn *wsConn) addPacket(p *protocol.Packet) {
-	select {
-	case conn.packetCh <- p:
-	default:
-		conn.logger.Warn("drop packet for channel full")
-	}
+func (conn *wsConn) addPacket(p *protocol.Packet) error {
+	return conn.queue.deliver(p, &conn.dopts, conn.closeCh)
 }
 
 func (conn *wsConn) writing() {
 	for {
-		b, ok := <-conn.writeCh
-		if !ok {
+		select {
+		case b := <-conn.writeCh:
+			if conn.closed() {
+				return
+			}
+
+			if err := conn.writeMessage(b); err != nil {
+				conn.Close(newDisconnectError(DisconnectWriteError, err))
+				return
+			}
+		case done := <-conn.flushCh:
+			if err := conn.flush(); err != nil {
+				conn.Close(newDisconnectError(DisconnectWriteError, err))
+				return
+			}
+
+			close(done)
+		case <-conn.closeCh:
 			return
 		}
+	}
+}
 
-		if conn.closed() {
-			return
+// flush writes all queued data
+func (conn *wsConn) flush() error {
+	for {
+		select {
+		case b := <-conn.writeCh:
+			if err := conn.writeMessage(b); err != nil {
+				return err
+			}
+		default:
+			return nil
 		}
+	}
+}
 
-		if err := conn.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
-			conn.Close(err)
-			return
+// writeMessage writes binary message with write deadline
+func (conn *wsConn) writeMessage(b []byte) error {
+	if conn.dopts.WriteTimeout > 0 {
+		if err := conn.conn.SetWriteDeadline(time.Now().Add(conn.dopts.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	return conn.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// Flush waits for all queued data written
+func (conn *wsConn) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+
+	select {
+	case conn.flushCh <- done:
+	case <-conn.closeCh:
+		return errConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-conn.closeCh:
+		// conn may be closed right after flushed
+		select {
+		case <-done:
+			return nil
+		default:
 		}
+		return errConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	control "github.com/longportapp/openapi-protobufs/gen/go/control"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestDialWSConn(t *testing.T) {
+	reqCh := make(chan *http.Request, 1)
+	closeCh := make(chan error, 1)
+
+	upgrader := websocket.Upgrader{
+		Subprotocols:      []string{"longport"},
+		EnableCompression: true,
+		CheckOrigin:       func(*http.Request) bool { return true },
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		reqCh <- r
+
+		// close code is read from raw frame, codes of gateway are not valid codes of RFC 6455
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(conn.UnderlyingConn(), b); err != nil || b[0] != 0x88 {
+			closeCh <- errors.Errorf("unexpected frame, err: %v", err)
+			return
+		}
+
+		// masked payload
+		b = make([]byte, 4+int(b[1]&0x7f))
+		if _, err = io.ReadFull(conn.UnderlyingConn(), b); err != nil {
+			closeCh <- err
+			return
+		}
+
+		payload := b[4:]
+		for i := range payload {
+			payload[i] ^= b[i%4]
+		}
+
+		closeCh <- &websocket.CloseError{Code: int(binary.BigEndian.Uint16(payload)), Text: string(payload[2:])}
+	}))
+	defer ts.Close()
+
+	uri, _ := url.Parse(strings.Replace(ts.URL, "http://", "ws://", 1) + "?token=abc&codec=9")
+
+	conn, err := dialWSConn(context.Background(), &protocol.DefaultLogger{}, uri, &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecJSON,
+		Version:  1,
+	}, newDialOptions(WSHeader("X-Api-Key", "key"), WSOrigin("https://example.com"), WSSubprotocols("longport"), WSCompression(true)))
+	assert.Nil(t, err)
+	defer conn.Close(nil)
+
+	r := <-reqCh
+
+	q := r.URL.Query()
+	assert.Equal(t, "abc", q.Get("token"))
+	assert.Equal(t, "1", q.Get("version"))
+	assert.Equal(t, "2", q.Get("codec"))
+	assert.Equal(t, "4", q.Get("platform"))
+
+	assert.Equal(t, "key", r.Header.Get("X-Api-Key"))
+	assert.Equal(t, "https://example.com", r.Header.Get("Origin"))
+	assert.Equal(t, "longport", r.Header.Get("Sec-Websocket-Protocol"))
+	assert.Contains(t, r.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	// close code is carried as it is
+	p := protocol.MustNewPush(conn.Context(), uint32(control.Command_CMD_CLOSE), &control.Close{Code: control.Close_ServerShutdown, Reason: "bye"})
+	assert.Nil(t, conn.Write(&p))
+
+	var ce *websocket.CloseError
+	assert.True(t, errors.As(<-closeCh, &ce))
+	assert.Equal(t, int(control.Close_ServerShutdown), ce.Code)
+	assert.Equal(t, "bye", ce.Text)
+}
+
+func TestWSConnCloseWhileWriting(t *testing.T) {
+	var upgrader websocket.Upgrader
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	uri, _ := url.Parse(strings.Replace(ts.URL, "http://", "ws://", 1))
+
+	conn, err := dialWSConn(context.Background(), &protocol.DefaultLogger{}, uri, &protocol.Handshake{
+		Platform: protocol.PlatformServer,
+		Codec:    protocol.CodecProtobuf,
+		Version:  1,
+	}, newDialOptions())
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	// writing to closed conn fails instead of panicking
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				p := protocol.MustNewPush(conn.Context(), 100, nil)
+
+				if err := conn.Write(&p); errors.Is(err, errConnClosed) {
+					return
+				}
+			}
+		}()
+	}
+
+	conn.Close(nil)
+	wg.Wait()
+}
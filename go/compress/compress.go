@@ -0,0 +1,21 @@
+// Package compress registers compressors of gzip, deflate, snappy and zstd,
+// importing it makes them usable by protocol codecs
+package compress
+
+import (
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// MaxDecompressedLength limits length of decompressed body, protects from decompression bomb
+const MaxDecompressedLength = 1 << 28
+
+var ErrDecompressedTooLarge = errors.New("decompressed data too large")
+
+func init() {
+	protocol.RegisterCompressor(newFlateCompressor(protocol.CompressionGzip))
+	protocol.RegisterCompressor(newFlateCompressor(protocol.CompressionDeflate))
+	protocol.RegisterCompressor(snappyCompressor{})
+	protocol.RegisterCompressor(newZstdCompressor())
+}
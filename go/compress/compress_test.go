@@ -0,0 +1,63 @@
+package compress
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestCompressors(t *testing.T) {
+	in, err := os.ReadFile("./testdata/plain")
+	assert.Nil(t, err)
+
+	assert.Equal(t, []protocol.CompressionType{
+		protocol.CompressionGzip,
+		protocol.CompressionDeflate,
+		protocol.CompressionSnappy,
+		protocol.CompressionZstd,
+	}, protocol.Compressions())
+
+	for _, typ := range protocol.Compressions() {
+		c, err := protocol.GetCompressor(typ)
+		assert.Nil(t, err)
+		assert.Equal(t, typ, c.Type())
+
+		for _, level := range []int{protocol.CompressionLevelDefault, 1, 9} {
+			out, err := c.Compress(in, level)
+			assert.Nil(t, err, typ)
+			assert.Less(t, len(out), len(in), typ)
+
+			t.Logf("%s level %d: %d -> %d", typ, level, len(in), len(out))
+
+			data, err := c.Decompress(out)
+			assert.Nil(t, err, typ)
+			assert.Equal(t, in, data, typ)
+		}
+
+		// empty body
+		out, err := c.Compress(nil, protocol.CompressionLevelDefault)
+		assert.Nil(t, err)
+
+		data, err := c.Decompress(out)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(data))
+
+		_, err = c.Decompress([]byte("not compressed data"))
+		assert.NotNil(t, err, typ)
+	}
+}
+
+func TestCompressorInvalidLevel(t *testing.T) {
+	for _, typ := range []protocol.CompressionType{protocol.CompressionGzip, protocol.CompressionDeflate, protocol.CompressionZstd} {
+		c, _ := protocol.GetCompressor(typ)
+
+		_, err := c.Compress([]byte("hello world"), 23)
+		assert.NotNil(t, err, typ)
+
+		_, err = c.Compress([]byte("hello world"), -1)
+		assert.NotNil(t, err, typ)
+	}
+}
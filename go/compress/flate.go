@@ -0,0 +1,116 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// flateWriter is implemented by gzip.Writer and flate.Writer
+type flateWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// flateCompressor compresses by gzip or raw deflate, writers are pooled per level
+type flateCompressor struct {
+	typ protocol.CompressionType
+	// index is level, level 0 is default level
+	writers [flate.BestCompression + 1]sync.Pool
+}
+
+func newFlateCompressor(t protocol.CompressionType) *flateCompressor {
+	return &flateCompressor{typ: t}
+}
+
+func (c *flateCompressor) Type() protocol.CompressionType {
+	return c.typ
+}
+
+func (c *flateCompressor) newWriter(level int) (flateWriter, error) {
+	if level == protocol.CompressionLevelDefault {
+		level = flate.DefaultCompression
+	}
+
+	if c.typ == protocol.CompressionGzip {
+		return gzip.NewWriterLevel(io.Discard, level)
+	}
+
+	return flate.NewWriter(io.Discard, level)
+}
+
+func (c *flateCompressor) Compress(data []byte, level int) ([]byte, error) {
+	if level < 0 || level > flate.BestCompression {
+		return nil, errors.Errorf("invalid %s compression level: %d", c.typ, level)
+	}
+
+	pool := &c.writers[level]
+
+	var (
+		w   flateWriter
+		err error
+	)
+
+	if v := pool.Get(); v != nil {
+		w = v.(flateWriter)
+	} else if w, err = c.newWriter(level); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	w.Reset(buf)
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	pool.Put(w)
+
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	if c.typ == protocol.CompressionGzip {
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+
+	defer r.Close()
+
+	return readLimited(r, len(data))
+}
+
+// readLimited reads all data of r, at most MaxDecompressedLength bytes
+func readLimited(r io.Reader, hint int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, hint*2+bytes.MinRead))
+
+	n, err := buf.ReadFrom(io.LimitReader(r, MaxDecompressedLength+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n > MaxDecompressedLength {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+package compress
+
+import (
+	"github.com/golang/snappy"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// snappyCompressor compresses by snappy block format, level is ignored
+type snappyCompressor struct{}
+
+func (snappyCompressor) Type() protocol.CompressionType {
+	return protocol.CompressionSnappy
+}
+
+func (snappyCompressor) Compress(data []byte, _ int) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n > MaxDecompressedLength {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return snappy.Decode(nil, data)
+}
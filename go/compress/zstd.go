@@ -0,0 +1,82 @@
+package compress
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// zstdCompressor compresses by zstd, level is zstd level from 1 to 22
+type zstdCompressor struct {
+	// encoders are created lazily per level, they are safe for concurrent EncodeAll
+	encoders sync.Map
+	decoder  *zstd.Decoder
+}
+
+func newZstdCompressor() *zstdCompressor {
+	// concurrency 0 makes decoder use GOMAXPROCS goroutines for DecodeAll
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxDecompressedLength))
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &zstdCompressor{decoder: d}
+}
+
+func (c *zstdCompressor) Type() protocol.CompressionType {
+	return protocol.CompressionZstd
+}
+
+func (c *zstdCompressor) encoder(level int) (*zstd.Encoder, error) {
+	l := zstd.SpeedDefault
+
+	if level != protocol.CompressionLevelDefault {
+		if level < 1 || level > 22 {
+			return nil, errors.Errorf("invalid zstd compression level: %d", level)
+		}
+
+		l = zstd.EncoderLevelFromZstd(level)
+	}
+
+	if e, ok := c.encoders.Load(l); ok {
+		return e.(*zstd.Encoder), nil
+	}
+
+	e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(l))
+
+	if err != nil {
+		return nil, err
+	}
+
+	actual, loaded := c.encoders.LoadOrStore(l, e)
+
+	if loaded {
+		_ = e.Close()
+	}
+
+	return actual.(*zstd.Encoder), nil
+}
+
+func (c *zstdCompressor) Compress(data []byte, level int) ([]byte, error) {
+	e, err := c.encoder(level)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	out, err := c.decoder.DecodeAll(data, nil)
+
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return out, err
+}
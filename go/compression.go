@@ -0,0 +1,173 @@
+package protocol
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// CompressionType is algorithm used to compress body
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = iota
+	CompressionGzip
+	CompressionDeflate
+	CompressionSnappy
+	CompressionZstd
+)
+
+// CompressionLevelDefault makes compressor use its default level
+const CompressionLevelDefault = 0
+
+var compressionStrings = []string{"none", "gzip", "deflate", "snappy", "zstd"}
+
+func (t CompressionType) String() string {
+	if int(t) >= len(compressionStrings) {
+		return "unknown"
+	}
+
+	return compressionStrings[int(t)]
+}
+
+// Compressor compresses and decompresses body
+type Compressor interface {
+	// Type return algorithm of compressor
+	Type() CompressionType
+	// Compress compresses data with level, CompressionLevelDefault means default level of algorithm
+	Compress(data []byte, level int) ([]byte, error)
+	// Decompress decompresses data
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[CompressionType]Compressor)
+)
+
+// RegisterCompressor registers compressor, the registered one of same type is replaced
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	compressors[c.Type()] = c
+}
+
+// GetCompressor returns compressor registered of type
+func GetCompressor(t CompressionType) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[t]
+
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCompression, "compression: %s", t)
+	}
+
+	return c, nil
+}
+
+// Compressions returns registered compression types in ascending order
+func Compressions() []CompressionType {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	types := make([]CompressionType, 0, len(compressors))
+
+	for t := range compressors {
+		types = append(types, t)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+
+	return types
+}
+
+// CompressBody compresses body of packet if it is not shorter than MinGzipSize of options
+func CompressBody(p *Packet, o *PackOptions) error {
+	if o.MinGzipSize == 0 || len(p.Body) < o.MinGzipSize {
+		return nil
+	}
+
+	t := o.Compression
+
+	if t == CompressionNone {
+		t = CompressionGzip
+	}
+
+	c, err := GetCompressor(t)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := c.Compress(p.Body, o.CompressionLevel)
+
+	if err != nil {
+		return errors.Wrapf(err, "compress body by %s", t)
+	}
+
+	p.Body = body
+	p.Metadata.Gzip = true
+	p.Metadata.Compression = t
+
+	return nil
+}
+
+// DecompressBody decompresses body of packet by Metadata.Compression, pooled body is released
+func DecompressBody(p *Packet) error {
+	if !p.Metadata.Gzip {
+		return nil
+	}
+
+	t := p.Metadata.Compression
+
+	if t == CompressionNone {
+		t = CompressionGzip
+	}
+
+	c, err := GetCompressor(t)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := c.Decompress(p.Body)
+
+	if err != nil {
+		return errors.Wrapf(err, "decompress body by %s", t)
+	}
+
+	// compressed body is not used anymore
+	p.Release()
+	p.Body = body
+
+	return nil
+}
+
+// AcceptCompression advertises compressions could be decompressed by Reserve of handshake,
+// gzip is always accepted
+func (h *Handshake) AcceptCompression(types ...CompressionType) {
+	for _, t := range types {
+		if t >= CompressionDeflate && t <= CompressionZstd {
+			h.Reserve |= 1 << (t - CompressionDeflate)
+		}
+	}
+}
+
+// AcceptsCompression reports whether body compressed by t could be sent to peer of handshake
+func (h Handshake) AcceptsCompression(t CompressionType) bool {
+	switch {
+	case t == CompressionNone || t == CompressionGzip:
+		return true
+	case t <= CompressionZstd:
+		return h.Reserve&(1<<(t-CompressionDeflate)) != 0
+	default:
+		return false
+	}
+}
@@ -5,7 +5,9 @@ go 1.17
 require (
 	github.com/Allenxuxu/ringbuffer v0.0.11
 	github.com/golang/protobuf v1.5.2
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/websocket v1.5.0
+	github.com/klauspost/compress v1.15.15
 	github.com/longbridgeapp/assert v0.1.0
 	github.com/longportapp/openapi-protobufs/gen/go v0.4.0
 	github.com/pkg/errors v0.9.1
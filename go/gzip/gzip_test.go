@@ -10,7 +10,7 @@ import (
 )
 
 func TestGzip_Compress(t *testing.T) {
-	f, err := os.Open("./testdata/plain")
+	f, err := os.Open("../compress/testdata/plain")
 	assert.Nil(t, err)
 
 	defer f.Close()
@@ -32,7 +32,7 @@ func TestGzip_Compress(t *testing.T) {
 }
 
 func TestGzip_Decompresse(t *testing.T) {
-	f, err := os.Open("./testdata/plain")
+	f, err := os.Open("../compress/testdata/plain")
 	assert.Nil(t, err)
 
 	defer f.Close()
@@ -52,7 +52,7 @@ func TestGzip_Decompresse(t *testing.T) {
 }
 
 func BenchmarkGzip_Comporess(b *testing.B) {
-	f, err := os.Open("./testdata/plain")
+	f, err := os.Open("../compress/testdata/plain")
 	if err != nil {
 		panic(err)
 	}
@@ -72,7 +72,7 @@ func BenchmarkGzip_Comporess(b *testing.B) {
 }
 
 func BenchmarkGzip_Decompress(b *testing.B) {
-	f, err := os.Open("./testdata/plain")
+	f, err := os.Open("../compress/testdata/plain")
 	if err != nil {
 		panic(err)
 	}
@@ -97,3 +97,17 @@ func BenchmarkGzip_Decompress(b *testing.B) {
 		_, _, _ = Decompress(data)
 	}
 }
+
+func TestGzip_SetLevel(t *testing.T) {
+	defer SetLevel(-1)
+
+	assert.NotNil(t, SetLevel(10))
+	assert.Nil(t, SetLevel(9))
+
+	cb, err := Compress([]byte("hello world"))
+	assert.Nil(t, err)
+
+	out, _, err := Decompress(cb)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello world"), out)
+}
@@ -0,0 +1,28 @@
+// Package frame holds helpers shared by frame codecs of protocol versions
+package frame
+
+import (
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+// CompressionReserve returns reserve bits identifying compression of compressed body,
+// gzip is 0 to be compatible with peers knowing gzip only
+func CompressionReserve(t protocol.CompressionType) (uint8, error) {
+	switch t {
+	case protocol.CompressionNone, protocol.CompressionGzip:
+		return 0, nil
+	case protocol.CompressionDeflate, protocol.CompressionSnappy, protocol.CompressionZstd:
+		return uint8(t) - 1, nil
+	default:
+		return 0, errors.Wrapf(protocol.ErrUnknownCompression, "compression %s can not be carried by header", t)
+	}
+}
+
+// ClearCopy copies src to dst and zeroes the rest of dst, so pooled frame won't carry stale bytes
+func ClearCopy(dst, src []byte) {
+	for i := copy(dst, src); i < len(dst); i++ {
+		dst[i] = 0
+	}
+}
@@ -0,0 +1,33 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+func TestCompressionReserve(t *testing.T) {
+	for c, want := range map[protocol.CompressionType]uint8{
+		protocol.CompressionNone:    0,
+		protocol.CompressionGzip:    0,
+		protocol.CompressionDeflate: 1,
+		protocol.CompressionSnappy:  2,
+		protocol.CompressionZstd:    3,
+	} {
+		r, err := CompressionReserve(c)
+		assert.Nil(t, err)
+		assert.Equal(t, want, r, c)
+	}
+
+	_, err := CompressionReserve(15)
+	assert.True(t, errors.Is(err, protocol.ErrUnknownCompression))
+}
+
+func TestClearCopy(t *testing.T) {
+	dst := []byte{0xff, 0xff, 0xff}
+	ClearCopy(dst, []byte{1})
+	assert.Equal(t, []byte{1, 0, 0}, dst)
+}
@@ -28,17 +28,19 @@ type KVPair struct {
 }
 
 type Metadata struct {
-	Nonce      uint64
-	RequestId  uint32
-	CmdCode    uint32
-	Verify     bool
-	Gzip       bool
-	Timeout    uint16
-	Codec      CodecType
-	StatusCode uint8
-	Type       PacketType
-	Signature  []byte
-	Values     map[string]string
+	Nonce     uint64
+	RequestId uint32
+	CmdCode   uint32
+	Verify    bool
+	// Gzip reports body is compressed, algorithm is Compression, gzip if it is none
+	Gzip        bool
+	Compression CompressionType
+	Timeout     uint16
+	Codec       CodecType
+	StatusCode  uint8
+	Type        PacketType
+	Signature   []byte
+	Values      map[string]string
 }
 
 func (md *Metadata) UnmarshalValues(data []byte) error {
@@ -25,6 +25,28 @@ func (p PacketType) String() string {
 type Packet struct {
 	Metadata *Metadata
 	Body     []byte
+
+	// pooled is the buffer of Body got from GetBuffer
+	pooled []byte
+}
+
+// SetPooledBody set body got from GetBuffer, it is put back to pools by Release
+func (p *Packet) SetPooledBody(b []byte) {
+	p.Body = b
+	p.pooled = b
+}
+
+// Release puts pooled body back to pools, Body must not be used after released.
+// It is optional, body is garbage collected if packet is not released
+func (p *Packet) Release() {
+	if p.pooled == nil {
+		return
+	}
+
+	PutBuffer(p.pooled)
+
+	p.pooled = nil
+	p.Body = nil
 }
 
 func (p Packet) UnmarshalMetadata(data []byte) error {
@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"sort"
+
 	"github.com/Allenxuxu/ringbuffer"
 	"github.com/pkg/errors"
 )
@@ -24,6 +26,21 @@ func Register(v uint8, p Protocol) {
 	manager[v] = p
 }
 
+// Versions returns registered protocol versions from highest to lowest
+func Versions() []uint8 {
+	vers := make([]uint8, 0, len(manager))
+
+	for v := range manager {
+		vers = append(vers, v)
+	}
+
+	sort.Slice(vers, func(i, j int) bool {
+		return vers[i] > vers[j]
+	})
+
+	return vers
+}
+
 type CodecType uint8
 
 const (
@@ -117,6 +134,18 @@ type Protocol interface {
 	Version() uint8
 }
 
+// ValuesMarshaler is implemented by protocols whose frames carry metadata values
+type ValuesMarshaler interface {
+	MarshalsValues() bool
+}
+
+// marshalsValues reports whether frames of protocol version v carry metadata values
+func marshalsValues(v uint8) bool {
+	m, ok := manager[v].(ValuesMarshaler)
+
+	return ok && m.MarshalsValues()
+}
+
 func GetProtocol(v uint8) (Protocol, error) {
 	p := manager[v]
 
@@ -128,7 +157,12 @@ func GetProtocol(v uint8) (Protocol, error) {
 }
 
 type PackOptions struct {
+	// MinGzipSize is min body length to compress, zero means body is not compressed
 	MinGzipSize int
+	// Compression is algorithm to compress body, gzip by default
+	Compression CompressionType
+	// CompressionLevel is level of Compression, CompressionLevelDefault by default
+	CompressionLevel int
 }
 
 type PackOption func(*PackOptions)
@@ -139,6 +173,14 @@ func GzipSize(n int) PackOption {
 	}
 }
 
+// WithCompression set algorithm and level to compress body
+func WithCompression(t CompressionType, level int) PackOption {
+	return func(o *PackOptions) {
+		o.Compression = t
+		o.CompressionLevel = level
+	}
+}
+
 func NewPackOptions(opts ...PackOption) *PackOptions {
 	o := &PackOptions{}
 
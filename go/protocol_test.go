@@ -1,8 +1,10 @@
 package protocol
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/longbridgeapp/assert"
 )
@@ -112,3 +114,158 @@ func TestHandshake_Unpack(t *testing.T) {
 		})
 	}
 }
+
+func TestBuffer(t *testing.T) {
+	b := GetBuffer(100)
+	assert.Equal(t, 100, len(b))
+	assert.Equal(t, 512, cap(b))
+	PutBuffer(b)
+
+	b = GetBuffer(5000)
+	assert.Equal(t, 5000, len(b))
+	assert.Equal(t, 8192, cap(b))
+
+	// larger than max class is not pooled
+	b = GetBuffer(4 << 20)
+	assert.Equal(t, 4<<20, cap(b))
+	PutBuffer(b)
+
+	assert.Equal(t, 0, len(GetBuffer(0)))
+
+	p := &Packet{}
+	p.SetPooledBody(GetBuffer(10))
+	p.Release()
+	assert.Nil(t, p.Body)
+
+	// release twice is safe
+	p.Release()
+}
+
+// valuesProtocol is a stub protocol whose frames carry metadata values
+type valuesProtocol struct {
+	Protocol
+}
+
+func (valuesProtocol) MarshalsValues() bool { return true }
+
+func TestSignPacket(t *testing.T) {
+	signer := NewHMACSigner([]byte("secret"))
+	v := NewVerifier(signer, time.Minute)
+
+	Register(255, valuesProtocol{})
+	defer delete(manager, 255)
+
+	ctx := &Context{Version: 255}
+
+	newPacket := func() *Packet {
+		return &Packet{
+			Metadata: &Metadata{Type: RequestPacket, CmdCode: 1, RequestId: 2, Timeout: 10},
+			Body:     []byte("hello world"),
+		}
+	}
+
+	p := newPacket()
+	assert.Equal(t, ErrPacketUnsigned, v.Verify(p))
+
+	SignPacket(ctx, p, signer)
+	assert.True(t, p.Metadata.Verify)
+	assert.Equal(t, SignatureSize, len(p.Metadata.Signature))
+	assert.Nil(t, v.Verify(p))
+
+	// replayed
+	assert.Equal(t, ErrNonceReplayed, v.Verify(p))
+
+	// tampered
+	p = newPacket()
+	SignPacket(ctx, p, signer)
+	p.Body = []byte("hello world!")
+	assert.Equal(t, ErrSignatureMismatch, v.Verify(p))
+
+	// metadata values are signed, keys are lowered as they are unmarshalled
+	p = newPacket()
+	p.Metadata.Values = map[string]string{"Trace_Id": "abc", "user": "1"}
+	SignPacket(ctx, p, signer)
+	p.Metadata.Values = map[string]string{"user": "1", "trace_id": "abc"}
+	assert.Nil(t, v.Verify(p))
+
+	p = newPacket()
+	p.Metadata.Values = map[string]string{"user": "1"}
+	SignPacket(ctx, p, signer)
+	p.Metadata.Values["user"] = "2"
+	assert.Equal(t, ErrSignatureMismatch, v.Verify(p))
+
+	// metadata values are not signed if frame doesn't carry them
+	p = newPacket()
+	p.Metadata.Values = map[string]string{"user": "1"}
+	SignPacket(&Context{Version: 254}, p, signer)
+	assert.Equal(t, map[string]string{"user": "1"}, p.Metadata.Values)
+	p.Metadata.Values = nil
+	assert.Nil(t, v.Verify(p))
+
+	// signed by another key
+	p = newPacket()
+	SignPacket(ctx, p, NewHMACSigner([]byte("other")))
+	assert.Equal(t, ErrSignatureMismatch, v.Verify(p))
+
+	// nonce out of window
+	p = newPacket()
+	nonce := uint64(time.Now().Add(-time.Hour).Unix()) << 32
+	WithVerify(nonce, signer.Sign(p.Metadata, p.Body, nonce))(p.Metadata)
+	assert.True(t, errors.Is(v.Verify(p), ErrNonceExpired))
+}
+
+type reverseCompressor struct{}
+
+func (reverseCompressor) Type() CompressionType { return 15 }
+
+func (reverseCompressor) Compress(data []byte, _ int) ([]byte, error) {
+	out := make([]byte, len(data))
+
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+
+	return out, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return c.Compress(data, 0)
+}
+
+func TestCompressBody(t *testing.T) {
+	RegisterCompressor(reverseCompressor{})
+
+	p := &Packet{Metadata: &Metadata{}, Body: []byte("hello")}
+
+	// shorter than threshold
+	assert.Nil(t, CompressBody(p, NewPackOptions(GzipSize(10), WithCompression(15, 0))))
+	assert.False(t, p.Metadata.Gzip)
+
+	assert.Nil(t, CompressBody(p, NewPackOptions(GzipSize(5), WithCompression(15, 0))))
+	assert.True(t, p.Metadata.Gzip)
+	assert.Equal(t, CompressionType(15), p.Metadata.Compression)
+	assert.Equal(t, "olleh", string(p.Body))
+
+	assert.Nil(t, DecompressBody(p))
+	assert.Equal(t, "hello", string(p.Body))
+
+	p.Metadata.Compression = 14
+	assert.True(t, errors.Is(DecompressBody(p), ErrUnknownCompression))
+}
+
+func TestHandshake_AcceptCompression(t *testing.T) {
+	h := Handshake{Version: 1}
+
+	assert.True(t, h.AcceptsCompression(CompressionGzip))
+	assert.False(t, h.AcceptsCompression(CompressionZstd))
+
+	h.AcceptCompression(CompressionGzip, CompressionSnappy, CompressionZstd)
+	assert.Equal(t, uint8(0b110), h.Reserve)
+
+	var h2 Handshake
+	assert.Nil(t, h2.Unpack(h.Pack()))
+	assert.False(t, h2.AcceptsCompression(CompressionDeflate))
+	assert.True(t, h2.AcceptsCompression(CompressionSnappy))
+	assert.True(t, h2.AcceptsCompression(CompressionZstd))
+	assert.False(t, h2.AcceptsCompression(15))
+}
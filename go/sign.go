@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureSize is length of signature carried by frame
+const SignatureSize = 16
+
+var (
+	ErrPacketUnsigned    = errors.New("packet is not signed")
+	ErrSignatureMismatch = errors.New("signature mismatch")
+	ErrNonceExpired      = errors.New("nonce is out of window")
+	ErrNonceReplayed     = errors.New("nonce is replayed")
+)
+
+// Signer computes signature of packet with nonce, signature must be SignatureSize bytes
+type Signer interface {
+	Sign(md *Metadata, body []byte, nonce uint64) []byte
+}
+
+// hmacSigner signs packet by HMAC-SHA256 truncated to SignatureSize
+type hmacSigner struct {
+	pool sync.Pool
+}
+
+// NewHMACSigner returns Signer using HMAC-SHA256 with key
+func NewHMACSigner(key []byte) Signer {
+	k := append([]byte(nil), key...)
+
+	return &hmacSigner{
+		pool: sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, k)
+			},
+		},
+	}
+}
+
+// Sign computes signature over header fields carried by frame of packet type, metadata values, body and nonce.
+// Gzip is not signed because body is signed before compressed
+func (s *hmacSigner) Sign(md *Metadata, body []byte, nonce uint64) []byte {
+	h := s.pool.Get().(hash.Hash)
+	defer s.pool.Put(h)
+
+	h.Reset()
+
+	var b [19]byte
+
+	b[0] = byte(len(md.Type))
+	binary.BigEndian.PutUint32(b[1:], md.CmdCode)
+
+	// fields absent in frame are left zero
+	switch md.Type {
+	case RequestPacket:
+		binary.BigEndian.PutUint32(b[5:], md.RequestId)
+		binary.BigEndian.PutUint16(b[9:], md.Timeout)
+	case ResponsePacket:
+		binary.BigEndian.PutUint32(b[5:], md.RequestId)
+		b[10] = md.StatusCode
+	}
+
+	binary.BigEndian.PutUint64(b[11:], nonce)
+
+	h.Write([]byte(md.Type))
+	h.Write(b[:])
+	signValues(h, md.Values)
+	h.Write(body)
+
+	return h.Sum(nil)[:SignatureSize]
+}
+
+// signValues writes values sorted by lowered key, values which are not marshalled into frame are skipped
+func signValues(h hash.Hash, values map[string]string) {
+	pairs := make([]KVPair, 0, len(values))
+
+	for k, v := range values {
+		if k == "" || len(k) > maxStringLength || len(v) > maxStringLength {
+			continue
+		}
+
+		pairs = append(pairs, KVPair{Key: strings.ToLower(k), Val: v})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	for _, pair := range pairs {
+		k, _ := marshalString(pair.Key)
+		v, _ := marshalString(pair.Val)
+
+		h.Write(k)
+		h.Write(v)
+	}
+}
+
+// NewNonce returns nonce whose high 32 bits are unix seconds and low 32 bits are random
+func NewNonce() uint64 {
+	var b [4]byte
+
+	_, _ = rand.Read(b[:])
+
+	return uint64(time.Now().Unix())<<32 | uint64(binary.BigEndian.Uint32(b[:]))
+}
+
+// nonceTime returns time carried by nonce made by NewNonce
+func nonceTime(nonce uint64) time.Time {
+	return time.Unix(int64(nonce>>32), 0)
+}
+
+// SignPacket signs packet with a new nonce, it must be invoked after body and metadata are set.
+// Metadata values are signed only if frames of ctx.Version carry them
+func SignPacket(ctx *Context, p *Packet, s Signer) {
+	nonce := NewNonce()
+	md := p.Metadata
+
+	if len(md.Values) > 0 && !marshalsValues(ctx.Version) {
+		m := *md
+		m.Values = nil
+		md = &m
+	}
+
+	WithVerify(nonce, s.Sign(md, p.Body, nonce))(p.Metadata)
+}
+
+// Verifier checks signature of packets and rejects nonces replayed inside window
+type Verifier struct {
+	signer Signer
+	window time.Duration
+
+	mu      sync.Mutex
+	seen    map[uint64]time.Time
+	sweptAt time.Time
+}
+
+// NewVerifier returns Verifier, nonces older or newer than window are rejected
+func NewVerifier(s Signer, window time.Duration) *Verifier {
+	return &Verifier{
+		signer:  s,
+		window:  window,
+		seen:    make(map[uint64]time.Time),
+		sweptAt: time.Now(),
+	}
+}
+
+// Verify returns error if packet is unsigned, tampered or replayed, packet must be unpacked from frame
+// so that it carries only values signed by peer
+func (v *Verifier) Verify(p *Packet) error {
+	md := p.Metadata
+
+	if !md.Verify {
+		return ErrPacketUnsigned
+	}
+
+	if !hmac.Equal(md.Signature, v.signer.Sign(md, p.Body, md.Nonce)) {
+		return ErrSignatureMismatch
+	}
+
+	now := time.Now()
+	t := nonceTime(md.Nonce)
+
+	if t.Before(now.Add(-v.window)) || t.After(now.Add(v.window)) {
+		return errors.Wrapf(ErrNonceExpired, "nonce time: %s", t)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.sweptAt) > v.window {
+		v.sweep(now)
+	}
+
+	if _, ok := v.seen[md.Nonce]; ok {
+		return ErrNonceReplayed
+	}
+
+	// nonce is rejected by window check after it expires
+	v.seen[md.Nonce] = t.Add(v.window)
+
+	return nil
+}
+
+// sweep removes expired nonces, it must be invoked with mu locked
+func (v *Verifier) sweep(now time.Time) {
+	for nonce, expiresAt := range v.seen {
+		if now.After(expiresAt) {
+			delete(v.seen, nonce)
+		}
+	}
+
+	v.sweptAt = now
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
 )
 
 var ErrUnknowPacket = errors.New("invalid packet type")
@@ -64,7 +65,7 @@ func (h Header) Metadata(ctx *protocol.Context) *protocol.Metadata {
 		t = protocol.PushPacket
 	}
 
-	return &protocol.Metadata{
+	md := &protocol.Metadata{
 		Type:       t,
 		Codec:      ctx.Codec,
 		Timeout:    h.Timeout,
@@ -74,6 +75,12 @@ func (h Header) Metadata(ctx *protocol.Context) *protocol.Metadata {
 		Verify:     h.Verify == 1,
 		Gzip:       h.Gzip == 1,
 	}
+
+	if md.Gzip {
+		md.Compression = protocol.CompressionType(h.Reserve + 1)
+	}
+
+	return md
 }
 
 func (h Header) IsUnknownPacket() bool {
@@ -375,6 +382,7 @@ func headerFromMetadata(md *protocol.Metadata) *Header {
 
 	if md.Gzip {
 		h.Gzip = 1
+		h.Reserve, _ = frame.CompressionReserve(md.Compression)
 	}
 
 	if md.Verify {
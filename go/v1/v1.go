@@ -6,7 +6,8 @@ import (
 	"github.com/Allenxuxu/ringbuffer"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
-	"github.com/longportapp/openapi-protocol/go/gzip"
+	_ "github.com/longportapp/openapi-protocol/go/compress"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
 )
 
 func init() {
@@ -65,10 +66,8 @@ func (p *protocolV1) UnpackBytes(ctx *protocol.Context, bs []byte) (packet *prot
 		packet.Metadata.Signature = data[idx+NonceLength:]
 	}
 
-	if header.Gzip == 1 {
-		if packet.Body, _, err = gzip.Decompress(packet.Body); err != nil {
-			return
-		}
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
 	}
 
 	ctx.EndUnpack()
@@ -114,15 +113,16 @@ func (p *protocolV1) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 		return
 	}
 
-	body := make([]byte, header.BodyLength)
+	body := protocol.GetBuffer(int(header.BodyLength))
 	if _, err = buf.Read(body); err != nil {
+		protocol.PutBuffer(body)
 		return
 	}
 
 	packet = &protocol.Packet{
 		Metadata: header.Metadata(ctx),
-		Body:     body,
 	}
+	packet.SetPooledBody(body)
 
 	if header.Verify == 1 {
 		packet.Metadata.Nonce = buf.PeekUint64()
@@ -135,10 +135,8 @@ func (p *protocolV1) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 		packet.Metadata.Signature = s
 	}
 
-	if header.Gzip == 1 {
-		if packet.Body, _, err = gzip.Decompress(packet.Body); err != nil {
-			return
-		}
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
 	}
 
 	// unpack is done
@@ -152,18 +150,16 @@ func (p *protocolV1) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 func (p *protocolV1) Pack(ctx *protocol.Context, packet *protocol.Packet, opts ...protocol.PackOption) ([]byte, error) {
 	o := protocol.NewPackOptions(opts...)
 
-	bl := len(packet.Body)
-
-	if o.MinGzipSize != 0 && bl >= o.MinGzipSize {
-		var err error
-		if packet.Body, err = gzip.Compress(packet.Body); err != nil {
-			return nil, err
-		}
+	if err := protocol.CompressBody(packet, o); err != nil {
+		return nil, err
+	}
 
-		bl = len(packet.Body)
-		packet.Metadata.Gzip = true
+	if _, err := frame.CompressionReserve(packet.Metadata.Compression); packet.Metadata.Gzip && err != nil {
+		return nil, err
 	}
 
+	bl := len(packet.Body)
+
 	if bl > MaxBodyLength {
 		return nil, ErrBodyLenHitLimit
 	}
@@ -199,14 +195,15 @@ func (p *protocolV1) Pack(ctx *protocol.Context, packet *protocol.Packet, opts .
 		l = l + NonceLength + SignatureLength
 	}
 
-	data := make([]byte, l)
+	// frame is pooled, it is put back by conn after written
+	data := protocol.GetBuffer(l)
 
 	copy(data, hd)
 	copy(data[len(hd):], packet.Body)
 
 	if packet.Metadata.Verify {
 		binary.BigEndian.PutUint64(data[hl+bl:hl+bl+NonceLength], packet.Metadata.Nonce)
-		copy(data[hl+bl+NonceLength:], packet.Metadata.Signature)
+		frame.ClearCopy(data[hl+bl+NonceLength:], packet.Metadata.Signature)
 	}
 
 	return data, nil
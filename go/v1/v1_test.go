@@ -2,12 +2,15 @@ package v1
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Allenxuxu/ringbuffer"
 	"github.com/stretchr/testify/assert"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
 )
 
 var v1 = &protocolV1{}
@@ -362,3 +365,102 @@ func TestGetV1Protocol(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestProtocolV1_Signature(t *testing.T) {
+	signer := protocol.NewHMACSigner([]byte("secret"))
+	verifier := protocol.NewVerifier(signer, time.Minute)
+	ctx := &protocol.Context{Version: 1}
+
+	for _, typ := range []protocol.PacketType{protocol.RequestPacket, protocol.ResponsePacket, protocol.PushPacket} {
+		packet := &protocol.Packet{
+			Metadata: &protocol.Metadata{
+				Type:      typ,
+				Timeout:   255,
+				RequestId: 1,
+				CmdCode:   1,
+				Values:    map[string]string{"trace_id": "abc"},
+			},
+			Body: []byte(strings.Repeat("hello world", 10)),
+		}
+
+		protocol.SignPacket(ctx, packet, signer)
+
+		// body is signed before compressed, values are not signed for they are dropped by frame
+		data, err := v1.Pack(ctx, packet, protocol.GzipSize(10))
+		assert.Nil(t, err)
+
+		p, err := v1.UnpackBytes(ctx, data)
+		assert.Nil(t, err)
+		assert.Nil(t, verifier.Verify(p), typ)
+	}
+}
+
+func TestProtocolV1_PackPooled(t *testing.T) {
+	packet := &protocol.Packet{
+		Metadata: &protocol.Metadata{
+			Type:    protocol.PushPacket,
+			CmdCode: 1,
+		},
+		Body: []byte("hello world"),
+	}
+
+	// signature shorter than frame field is padded with zero
+	protocol.WithVerify(1, []byte{1, 2})(packet.Metadata)
+
+	// dirty pooled buffer which may be reused by Pack
+	b := protocol.GetBuffer(PushHeaderLen + len(packet.Body) + NonceLength + SignatureLength)
+	for i := range b {
+		b[i] = 0xff
+	}
+	protocol.PutBuffer(b)
+
+	data, err := v1.Pack(&protocol.Context{}, packet)
+	assert.Nil(t, err)
+
+	sig := make([]byte, SignatureLength)
+	sig[0], sig[1] = 1, 2
+	assert.Equal(t, sig, data[len(data)-SignatureLength:])
+
+	protocol.PutBuffer(data)
+}
+
+func TestProtocolV1_Compression(t *testing.T) {
+	body := []byte(strings.Repeat("hello world", 100))
+
+	for _, c := range []protocol.CompressionType{protocol.CompressionNone, protocol.CompressionGzip, protocol.CompressionDeflate, protocol.CompressionSnappy, protocol.CompressionZstd} {
+		packet := &protocol.Packet{
+			Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3},
+			Body:     body,
+		}
+
+		data, err := v1.Pack(&protocol.Context{}, packet, protocol.GzipSize(10), protocol.WithCompression(c, protocol.CompressionLevelDefault))
+		assert.Nil(t, err)
+
+		// gzip bit is set, reserve bits are 0 for gzip
+		reserve, _ := frame.CompressionReserve(c)
+		assert.Equal(t, uint8(1), data[0]>>5&0x1, c)
+		assert.Equal(t, reserve, data[0]>>6, c)
+
+		p, err := v1.UnpackBytes(&protocol.Context{}, data)
+		assert.Nil(t, err)
+		assert.Equal(t, body, p.Body)
+		assert.True(t, p.Metadata.Gzip)
+
+		buf := ringbuffer.New(len(data))
+		_, _ = buf.Write(data)
+
+		p, done, err := v1.Unpack(&protocol.Context{}, buf)
+		assert.Nil(t, err)
+		assert.True(t, done)
+		assert.Equal(t, body, p.Body)
+	}
+
+	// compression can not be carried by header
+	packet := &protocol.Packet{
+		Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3, Gzip: true, Compression: 15},
+		Body:     body,
+	}
+
+	_, err := v1.Pack(&protocol.Context{}, packet)
+	assert.ErrorIs(t, err, protocol.ErrUnknownCompression)
+}
@@ -6,7 +6,8 @@ import (
 	"github.com/Allenxuxu/ringbuffer"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
-	"github.com/longportapp/openapi-protocol/go/gzip"
+	_ "github.com/longportapp/openapi-protocol/go/compress"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
 	v1 "github.com/longportapp/openapi-protocol/go/v1"
 )
 
@@ -20,6 +21,9 @@ type protocolV2 struct{}
 
 func (protocolV2) Version() uint8 { return 2 }
 
+// MarshalsValues implements protocol.ValuesMarshaler, metadata values are carried by frame
+func (protocolV2) MarshalsValues() bool { return true }
+
 func (p *protocolV2) UnpackBytes(ctx *protocol.Context, bs []byte) (packet *protocol.Packet, err error) {
 	ctx.BeginUnpack()
 	header := headerFromContext(ctx)
@@ -64,10 +68,8 @@ func (p *protocolV2) UnpackBytes(ctx *protocol.Context, bs []byte) (packet *prot
 		packet.Metadata.Signature = data[idx+v1.NonceLength:]
 	}
 
-	if header.Gzip == 1 {
-		if packet.Body, _, err = gzip.Decompress(packet.Body); err != nil {
-			return
-		}
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
 	}
 
 	ctx.EndUnpack()
@@ -114,22 +116,24 @@ func (p *protocolV2) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 	}
 
 	// read metadata
-	md := make([]byte, header.MetadataLength)
+	md := protocol.GetBuffer(int(header.MetadataLength))
+	defer protocol.PutBuffer(md)
 
 	if _, err = buf.Read(md); err != nil {
 		return
 	}
 
 	// read body
-	body := make([]byte, header.BodyLength)
+	body := protocol.GetBuffer(int(header.BodyLength))
 	if _, err = buf.Read(body); err != nil {
+		protocol.PutBuffer(body)
 		return
 	}
 
 	packet = &protocol.Packet{
 		Metadata: header.Metadata(ctx),
-		Body:     body,
 	}
+	packet.SetPooledBody(body)
 
 	if err = packet.UnmarshalMetadata(md); err != nil {
 		return
@@ -146,10 +150,8 @@ func (p *protocolV2) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 		packet.Metadata.Signature = s
 	}
 
-	if header.Gzip == 1 {
-		if packet.Body, _, err = gzip.Decompress(packet.Body); err != nil {
-			return
-		}
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
 	}
 
 	// unpack is done
@@ -163,18 +165,16 @@ func (p *protocolV2) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (
 func (p *protocolV2) Pack(ctx *protocol.Context, packet *protocol.Packet, opts ...protocol.PackOption) ([]byte, error) {
 	o := protocol.NewPackOptions(opts...)
 
-	bl := len(packet.Body)
-
-	if o.MinGzipSize != 0 && bl >= o.MinGzipSize {
-		var err error
-		if packet.Body, err = gzip.Compress(packet.Body); err != nil {
-			return nil, err
-		}
+	if err := protocol.CompressBody(packet, o); err != nil {
+		return nil, err
+	}
 
-		bl = len(packet.Body)
-		packet.Metadata.Gzip = true
+	if _, err := frame.CompressionReserve(packet.Metadata.Compression); packet.Metadata.Gzip && err != nil {
+		return nil, err
 	}
 
+	bl := len(packet.Body)
+
 	if bl > v1.MaxBodyLength {
 		return nil, v1.ErrBodyLenHitLimit
 	}
@@ -213,15 +213,18 @@ func (p *protocolV2) Pack(ctx *protocol.Context, packet *protocol.Packet, opts .
 		l = l + v1.NonceLength + v1.SignatureLength
 	}
 
-	data := make([]byte, l)
+	// frame is pooled, it is put back by conn after written
+	data := protocol.GetBuffer(l)
 
 	copy(data, hd)
 	copy(data[len(hd):], md)
 	copy(data[len(hd)+len(md):], packet.Body)
 
 	if packet.Metadata.Verify {
-		binary.BigEndian.PutUint64(data[hl+bl:hl+bl+v1.NonceLength], packet.Metadata.Nonce)
-		copy(data[hl+bl+v1.NonceLength:], packet.Metadata.Signature)
+		idx := hl + len(md) + bl
+
+		binary.BigEndian.PutUint64(data[idx:idx+v1.NonceLength], packet.Metadata.Nonce)
+		frame.ClearCopy(data[idx+v1.NonceLength:], packet.Metadata.Signature)
 	}
 
 	return data, nil
@@ -7,6 +7,7 @@ import (
 	"github.com/Allenxuxu/ringbuffer"
 
 	protocol "github.com/longportapp/openapi-protocol/go"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
 	v1 "github.com/longportapp/openapi-protocol/go/v1"
 )
 
@@ -313,6 +314,7 @@ func headerFromMetadata(md *protocol.Metadata) *Header {
 
 	if md.Gzip {
 		h.Gzip = 1
+		h.Reserve, _ = frame.CompressionReserve(md.Compression)
 	}
 
 	if md.Verify {
@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Allenxuxu/ringbuffer"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+)
+
+var v2 = &protocolV2{}
+
+func TestProtocolV2_Signature(t *testing.T) {
+	signer := protocol.NewHMACSigner([]byte("secret"))
+	verifier := protocol.NewVerifier(signer, time.Minute)
+	ctx := &protocol.Context{Version: 2}
+
+	for _, typ := range []protocol.PacketType{protocol.RequestPacket, protocol.ResponsePacket, protocol.PushPacket} {
+		packet := &protocol.Packet{
+			Metadata: &protocol.Metadata{
+				Type:      typ,
+				Timeout:   255,
+				RequestId: 1,
+				CmdCode:   1,
+				Values:    map[string]string{"trace_id": "abc"},
+			},
+			Body: []byte(strings.Repeat("hello world", 10)),
+		}
+
+		protocol.SignPacket(ctx, packet, signer)
+
+		data, err := v2.Pack(ctx, packet, protocol.GzipSize(10))
+		assert.Nil(t, err)
+
+		p, err := v2.UnpackBytes(ctx, data)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc", p.GetMetadata("trace_id"))
+		assert.Nil(t, verifier.Verify(p), typ)
+
+		// unpack from stream
+		buf := ringbuffer.New(1024)
+		_, _ = buf.Write(data)
+
+		p, done, err := v2.Unpack(protocol.NewContext(context.Background(), protocol.ClientSide), buf)
+		assert.Nil(t, err)
+		assert.True(t, done)
+		assert.Equal(t, packet.Metadata.Signature, p.Metadata.Signature)
+	}
+}
@@ -0,0 +1,226 @@
+package v3
+
+import (
+	"encoding/binary"
+
+	"github.com/Allenxuxu/ringbuffer"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+	_ "github.com/longportapp/openapi-protocol/go/compress"
+	"github.com/longportapp/openapi-protocol/go/internal/frame"
+	v1 "github.com/longportapp/openapi-protocol/go/v1"
+)
+
+func init() {
+	protocol.Register(3, &protocolV3{})
+}
+
+var _ (protocol.Protocol) = (*protocolV3)(nil)
+
+// protocolV3 carries varint cmd code, 32-bit body length, compression and codec of each packet
+type protocolV3 struct{}
+
+func (protocolV3) Version() uint8 { return 3 }
+
+// MarshalsValues implements protocol.ValuesMarshaler, metadata values are carried by frame
+func (protocolV3) MarshalsValues() bool { return true }
+
+func (p *protocolV3) UnpackBytes(ctx *protocol.Context, bs []byte) (packet *protocol.Packet, err error) {
+	ctx.BeginUnpack()
+	header := headerFromContext(ctx)
+
+	defer func() {
+		ctx.SetHeader(nil)
+		defaultHeaderPool.Put(header)
+	}()
+
+	data, e := header.UnpackBytes(ctx, bs)
+
+	if e != nil {
+		err = e
+		return
+	}
+
+	ml := int(header.MetadataLength)
+	bl := int(header.BodyLength)
+
+	if len(data) < ml+bl {
+		err = v1.ErrInvalidFrame
+		return
+	}
+
+	packet = &protocol.Packet{
+		Metadata: header.Metadata(ctx),
+		Body:     data[ml : ml+bl],
+	}
+
+	if err = packet.UnmarshalMetadata(data[:ml]); err != nil {
+		return
+	}
+
+	if header.Verify == 1 {
+		idx := ml + bl
+
+		if len(data) < idx+v1.NonceLength+v1.SignatureLength {
+			err = v1.ErrInvalidFrame
+			return
+		}
+
+		packet.Metadata.Nonce = binary.BigEndian.Uint64(data[idx : idx+v1.NonceLength])
+		packet.Metadata.Signature = data[idx+v1.NonceLength : idx+v1.NonceLength+v1.SignatureLength]
+	}
+
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
+	}
+
+	ctx.EndUnpack()
+
+	return
+}
+
+func (p *protocolV3) Unpack(ctx *protocol.Context, buf *ringbuffer.RingBuffer) (packet *protocol.Packet, done bool, err error) {
+	ctx.BeginUnpack()
+
+	header := headerFromContext(ctx)
+
+	defer func() {
+		// if done or err raised, release header
+		if done || err != nil {
+			ctx.SetHeader(nil)
+			defaultHeaderPool.Put(header)
+		}
+	}()
+
+	if !header.Unpacked() {
+		ok, e := header.Unpack(ctx, buf)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		// waiting header data
+		if !ok {
+			return
+		}
+	}
+
+	len := int(header.BodyLength) + int(header.MetadataLength)
+
+	if header.Verify == 1 {
+		len = len + v1.NonceLength + v1.SignatureLength
+	}
+
+	// wait all byte ready
+	if buf.Length() < len {
+		return
+	}
+
+	// read metadata
+	md := protocol.GetBuffer(int(header.MetadataLength))
+	defer protocol.PutBuffer(md)
+
+	if _, err = buf.Read(md); err != nil {
+		return
+	}
+
+	// read body
+	body := protocol.GetBuffer(int(header.BodyLength))
+	if _, err = buf.Read(body); err != nil {
+		protocol.PutBuffer(body)
+		return
+	}
+
+	packet = &protocol.Packet{
+		Metadata: header.Metadata(ctx),
+	}
+	packet.SetPooledBody(body)
+
+	if err = packet.UnmarshalMetadata(md); err != nil {
+		return
+	}
+
+	if header.Verify == 1 {
+		packet.Metadata.Nonce = buf.PeekUint64()
+		buf.Retrieve(v1.NonceLength)
+
+		s := make([]byte, v1.SignatureLength)
+		if _, err = buf.Read(s); err != nil {
+			return
+		}
+		packet.Metadata.Signature = s
+	}
+
+	if err = protocol.DecompressBody(packet); err != nil {
+		return
+	}
+
+	// unpack is done
+	done = true
+
+	ctx.EndUnpack()
+	return
+}
+
+func (p *protocolV3) Pack(ctx *protocol.Context, packet *protocol.Packet, opts ...protocol.PackOption) ([]byte, error) {
+	o := protocol.NewPackOptions(opts...)
+
+	if err := protocol.CompressBody(packet, o); err != nil {
+		return nil, err
+	}
+
+	bl := len(packet.Body)
+
+	if uint64(bl) > MaxBodyLength {
+		return nil, v1.ErrBodyLenHitLimit
+	}
+
+	h := headerFromMetadata(packet.Metadata)
+	defer func() {
+		defaultHeaderPool.Put(h)
+	}()
+
+	md := packet.MarshalMetadata(MaxMetadataLength)
+
+	// peer rejects frame over limit, so it is not sent
+	if uint64(len(md))+uint64(bl) > uint64(MaxFrameLength()) {
+		return nil, v1.ErrBodyLenHitLimit
+	}
+
+	if len(md) > 0 {
+		h.HasMetadata = 1
+		h.MetadataLength = uint32(len(md))
+	}
+
+	h.BodyLength = uint32(bl)
+
+	hd, err := h.Pack()
+
+	if err != nil {
+		return nil, err
+	}
+
+	hl := len(hd)
+	l := hl + len(md) + bl
+
+	if packet.Metadata.Verify {
+		l = l + v1.NonceLength + v1.SignatureLength
+	}
+
+	// frame is pooled, it is put back by conn after written
+	data := protocol.GetBuffer(l)
+
+	copy(data, hd)
+	copy(data[hl:], md)
+	copy(data[hl+len(md):], packet.Body)
+
+	if packet.Metadata.Verify {
+		idx := hl + len(md) + bl
+
+		binary.BigEndian.PutUint64(data[idx:idx+v1.NonceLength], packet.Metadata.Nonce)
+		frame.ClearCopy(data[idx+v1.NonceLength:], packet.Metadata.Signature)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,386 @@
+package v3
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Allenxuxu/ringbuffer"
+	"github.com/pkg/errors"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+	v1 "github.com/longportapp/openapi-protocol/go/v1"
+)
+
+const (
+	MaxBodyLength     = math.MaxUint32
+	MaxMetadataLength = 1<<20 - 1
+
+	// flags:8, compression_codec:8, cmd_code:varint, request_id:32, timeout:16, metadata_len:varint, body_len:32
+	MaxHeaderLen = 2 + binary.MaxVarintLen32 + 4 + 2 + binary.MaxVarintLen32 + 4
+
+	// DefaultMaxFrameLength is default limit of metadata and body length of frame
+	DefaultMaxFrameLength = 1<<26 - 1
+)
+
+var maxFrameLength uint32 = DefaultMaxFrameLength
+
+// SetMaxFrameLength sets limit of metadata and body length of frame,
+// frames read from peer over it are rejected before they are buffered and frames over it are not packed
+func SetMaxFrameLength(n uint32) {
+	atomic.StoreUint32(&maxFrameLength, n)
+}
+
+// MaxFrameLength returns limit of metadata and body length of frame
+func MaxFrameLength() uint32 {
+	return atomic.LoadUint32(&maxFrameLength)
+}
+
+// Header of v3 frame:
+//
+//	type:4, verify:1, has_metadata:1, reserve:2
+//	compression:4 (protocol.CompressionType), codec:4
+//	cmd_code:varint
+//	request_id:32 (request and response)
+//	timeout:16 (request)
+//	status_code:8 (response)
+//	metadata_len:varint (has_metadata)
+//	body_len:32
+type Header struct {
+	CmdCode        uint32
+	RequestId      uint32
+	BodyLength     uint32
+	MetadataLength uint32
+	Timeout        uint16
+	Type           uint8
+	Verify         uint8
+	HasMetadata    uint8
+	Reserve        uint8
+	Compression    uint8
+	Codec          uint8
+	StatusCode     uint8
+
+	IsUnpacked bool
+}
+
+func (h Header) Metadata(ctx *protocol.Context) *protocol.Metadata {
+	var t protocol.PacketType
+
+	switch v1.PacketType(h.Type) {
+	case v1.RequestPacket:
+		t = protocol.RequestPacket
+	case v1.ResponsePacket:
+		t = protocol.ResponsePacket
+	case v1.PushPacket:
+		t = protocol.PushPacket
+	}
+
+	codec := protocol.CodecType(h.Codec)
+
+	// codec of conn is used if packet does not carry one
+	if codec == protocol.CodecUnknown {
+		codec = ctx.Codec
+	}
+
+	return &protocol.Metadata{
+		Type:        t,
+		Codec:       codec,
+		Timeout:     h.Timeout,
+		CmdCode:     h.CmdCode,
+		RequestId:   h.RequestId,
+		StatusCode:  h.StatusCode,
+		Verify:      h.Verify == 1,
+		Gzip:        h.Compression != uint8(protocol.CompressionNone),
+		Compression: protocol.CompressionType(h.Compression),
+	}
+}
+
+func (h Header) IsUnknownPacket() bool {
+	switch v1.PacketType(h.Type) {
+	case v1.RequestPacket, v1.ResponsePacket, v1.PushPacket:
+		return false
+	default:
+		return true
+	}
+}
+
+func (h Header) Unpacked() bool {
+	return h.IsUnpacked
+}
+
+func (h Header) Pack() ([]byte, error) {
+	if h.IsUnknownPacket() {
+		return nil, v1.ErrUnknowPacket
+	}
+
+	if h.MetadataLength > MaxMetadataLength {
+		return nil, errors.New("metadata length hit limit")
+	}
+
+	data := make([]byte, MaxHeaderLen)
+
+	var idx int
+
+	// put type:4,verify:1,has_metadata:1,reserve:2
+	data[idx] = (h.Type & 0xf) | ((h.Verify & 0x1) << 4) | ((h.HasMetadata & 0x1) << 5) | ((h.Reserve & 0x3) << 6)
+	idx++
+
+	// put compression:4,codec:4
+	data[idx] = (h.Compression & 0xf) | ((h.Codec & 0xf) << 4)
+	idx++
+
+	// put cmd_code:varint
+	idx += binary.PutUvarint(data[idx:], uint64(h.CmdCode))
+
+	t := v1.PacketType(h.Type)
+
+	if t == v1.RequestPacket || t == v1.ResponsePacket {
+		// put request_id:32
+		binary.BigEndian.PutUint32(data[idx:idx+4], h.RequestId)
+		idx = idx + 4
+	}
+
+	if t == v1.RequestPacket {
+		// put timeout:16
+		binary.BigEndian.PutUint16(data[idx:idx+2], h.Timeout)
+		idx = idx + 2
+	}
+
+	if t == v1.ResponsePacket {
+		// put status_code:8
+		data[idx] = h.StatusCode
+		idx = idx + 1
+	}
+
+	if h.HasMetadata == 1 {
+		// put metadata_len:varint
+		idx += binary.PutUvarint(data[idx:], uint64(h.MetadataLength))
+	}
+
+	// put body_len:32
+	binary.BigEndian.PutUint32(data[idx:idx+4], h.BodyLength)
+	idx = idx + 4
+
+	return data[:idx], nil
+}
+
+// unpack parses header from b and returns length of header, done is false if b is too short
+func (h *Header) unpack(b []byte) (n int, done bool, err error) {
+	if len(b) < 2 {
+		return
+	}
+
+	h.Type = v1.HeaderTypeMask & b[0]
+	h.Verify = b[0] >> 4 & 0x1
+	h.HasMetadata = b[0] >> 5 & 0x1
+	h.Reserve = b[0] >> 6 & 0x3
+
+	if h.IsUnknownPacket() {
+		err = v1.ErrUnknowPacket
+		return
+	}
+
+	h.Compression = b[1] & 0xf
+	h.Codec = b[1] >> 4
+
+	if h.Compression != uint8(protocol.CompressionNone) {
+		if _, err = protocol.GetCompressor(protocol.CompressionType(h.Compression)); err != nil {
+			return
+		}
+	}
+
+	idx := 2
+
+	cmd, l := binary.Uvarint(b[idx:])
+
+	if l == 0 {
+		return
+	}
+
+	if l < 0 || cmd > math.MaxUint32 {
+		err = v1.ErrInvalidFrame
+		return
+	}
+
+	h.CmdCode = uint32(cmd)
+	idx += l
+
+	t := v1.PacketType(h.Type)
+
+	if t == v1.RequestPacket || t == v1.ResponsePacket {
+		if len(b) < idx+4 {
+			return
+		}
+
+		h.RequestId = binary.BigEndian.Uint32(b[idx : idx+4])
+		idx = idx + 4
+	}
+
+	if t == v1.RequestPacket {
+		if len(b) < idx+2 {
+			return
+		}
+
+		h.Timeout = binary.BigEndian.Uint16(b[idx : idx+2])
+		idx = idx + 2
+	}
+
+	if t == v1.ResponsePacket {
+		if len(b) < idx+1 {
+			return
+		}
+
+		h.StatusCode = b[idx]
+		idx = idx + 1
+	}
+
+	if h.HasMetadata == 1 {
+		ml, l := binary.Uvarint(b[idx:])
+
+		if l == 0 {
+			return
+		}
+
+		if l < 0 || ml > MaxMetadataLength {
+			err = v1.ErrInvalidFrame
+			return
+		}
+
+		h.MetadataLength = uint32(ml)
+		idx += l
+	}
+
+	if len(b) < idx+4 {
+		return
+	}
+
+	h.BodyLength = binary.BigEndian.Uint32(b[idx : idx+4])
+	idx = idx + 4
+
+	if uint64(h.MetadataLength)+uint64(h.BodyLength) > uint64(MaxFrameLength()) {
+		err = v1.ErrBodyLenHitLimit
+		return
+	}
+
+	return idx, true, nil
+}
+
+func (h *Header) UnpackBytes(ctx *protocol.Context, frame []byte) (data []byte, err error) {
+	n, done, err := h.unpack(frame)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !done {
+		return nil, v1.ErrInvalidFrame
+	}
+
+	h.IsUnpacked = true
+
+	return frame[n:], nil
+}
+
+func (h *Header) Unpack(ctx *protocol.Context, buffer *ringbuffer.RingBuffer) (done bool, err error) {
+	if h.IsUnpacked {
+		done = true
+		return
+	}
+
+	l := buffer.Length()
+
+	if l == 0 {
+		return
+	}
+
+	if l > MaxHeaderLen {
+		l = MaxHeaderLen
+	}
+
+	// header is parsed from a copy, buffer may wrap around
+	var b [MaxHeaderLen]byte
+
+	first, end := buffer.Peek(l)
+	n := copy(b[:], first)
+	copy(b[n:], end)
+
+	n, done, err = h.unpack(b[:l])
+
+	// waiting data
+	if err != nil || !done {
+		return
+	}
+
+	buffer.Retrieve(n)
+	h.IsUnpacked = true
+
+	return
+}
+
+var defaultHeaderPool = &headerPool{
+	Pool: &sync.Pool{
+		New: func() interface{} {
+			return &Header{}
+		},
+	},
+}
+
+type headerPool struct {
+	*sync.Pool
+}
+
+func (p *headerPool) Get() *Header {
+	h := p.Pool.Get().(*Header)
+
+	*h = Header{}
+
+	return h
+}
+
+func headerFromContext(ctx *protocol.Context) (h *Header) {
+	v := ctx.GetHeader()
+
+	if v == nil {
+		h = defaultHeaderPool.Get()
+		ctx.SetHeader(h)
+	} else {
+		h = v.(*Header)
+	}
+
+	return
+}
+
+func headerFromMetadata(md *protocol.Metadata) *Header {
+	h := defaultHeaderPool.Get()
+
+	if md.Gzip {
+		h.Compression = uint8(md.Compression)
+
+		// compressed by gzip if compression is not set
+		if md.Compression == protocol.CompressionNone {
+			h.Compression = uint8(protocol.CompressionGzip)
+		}
+	}
+
+	if md.Verify {
+		h.Verify = 1
+	}
+
+	switch md.Type {
+	case protocol.RequestPacket:
+		h.Type = uint8(v1.RequestPacket)
+	case protocol.ResponsePacket:
+		h.Type = uint8(v1.ResponsePacket)
+	case protocol.PushPacket:
+		h.Type = uint8(v1.PushPacket)
+	}
+
+	h.Codec = uint8(md.Codec)
+	h.RequestId = md.RequestId
+	h.CmdCode = md.CmdCode
+	h.Timeout = md.Timeout
+	h.StatusCode = md.StatusCode
+
+	return h
+}
@@ -0,0 +1,123 @@
+package v3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+	v1 "github.com/longportapp/openapi-protocol/go/v1"
+)
+
+func TestHeader_Pack(t *testing.T) {
+	cases := []struct {
+		label  string
+		header Header
+		bytes  []byte
+		err    error
+	}{
+		{
+			label: "request header",
+			header: Header{
+				Type:       uint8(v1.RequestPacket),
+				Codec:      uint8(protocol.CodecProtobuf),
+				CmdCode:    300,
+				RequestId:  1,
+				Timeout:    255,
+				BodyLength: 1 << 24,
+			},
+			bytes: []byte{0b00000001, 0b00010000, 0xac, 0x02, 0, 0, 0, 1, 0, 255, 1, 0, 0, 0},
+		},
+		{
+			label: "response header with metadata",
+			header: Header{
+				Type:           uint8(v1.ResponsePacket),
+				Compression:    uint8(protocol.CompressionGzip),
+				Codec:          uint8(protocol.CodecJSON),
+				HasMetadata:    1,
+				Verify:         1,
+				CmdCode:        1,
+				RequestId:      1,
+				StatusCode:     2,
+				MetadataLength: 200,
+				BodyLength:     11,
+			},
+			bytes: []byte{0b00110010, 0b00100001, 1, 0, 0, 0, 1, 2, 0xc8, 0x01, 0, 0, 0, 11},
+		},
+		{
+			label: "push header",
+			header: Header{
+				Type:       uint8(v1.PushPacket),
+				CmdCode:    3,
+				BodyLength: 11,
+			},
+			bytes: []byte{0b00000011, 0, 3, 0, 0, 0, 11},
+		},
+		{
+			label:  "unknown packet",
+			header: Header{},
+			err:    v1.ErrUnknowPacket,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			data, err := c.header.Pack()
+
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.bytes, data)
+
+			if err != nil {
+				return
+			}
+
+			var h Header
+
+			n, done, err := h.unpack(data)
+			assert.Nil(t, err)
+			assert.True(t, done)
+			assert.Equal(t, len(data), n)
+			assert.Equal(t, c.header, h)
+
+			// every prefix is waiting for more data
+			for i := 0; i < len(data); i++ {
+				var h Header
+
+				_, done, err := h.unpack(data[:i])
+				assert.Nil(t, err)
+				assert.False(t, done)
+			}
+		})
+	}
+}
+
+func TestHeader_UnpackInvalid(t *testing.T) {
+	var h Header
+
+	_, _, err := h.unpack([]byte{0b00000011, 0x0f, 3})
+	assert.ErrorIs(t, err, protocol.ErrUnknownCompression)
+
+	// cmd code overflows uint32
+	_, _, err = h.unpack([]byte{0b00000011, 0, 0xff, 0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0})
+	assert.Equal(t, v1.ErrInvalidFrame, err)
+}
+
+func TestHeader_UnpackFrameLimit(t *testing.T) {
+	defer SetMaxFrameLength(DefaultMaxFrameLength)
+
+	var h Header
+
+	// push with body of 64 MiB
+	frame := []byte{0b00000011, 0, 1, 0x04, 0, 0, 0}
+
+	_, _, err := h.unpack(frame)
+	assert.Equal(t, v1.ErrBodyLenHitLimit, err)
+
+	SetMaxFrameLength(1 << 26)
+
+	_, done, err := h.unpack(frame)
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, uint32(1<<26), h.BodyLength)
+}
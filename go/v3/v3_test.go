@@ -0,0 +1,169 @@
+package v3
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Allenxuxu/ringbuffer"
+	"github.com/stretchr/testify/assert"
+
+	protocol "github.com/longportapp/openapi-protocol/go"
+	v1 "github.com/longportapp/openapi-protocol/go/v1"
+)
+
+var v3 = &protocolV3{}
+
+func TestGetV3Protocol(t *testing.T) {
+	p, err := protocol.GetProtocol(3)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), p.Version())
+}
+
+func TestProtocolV3_PackUnpack(t *testing.T) {
+	signer := protocol.NewHMACSigner([]byte("secret"))
+
+	cases := []struct {
+		label       string
+		packet      *protocol.Packet
+		gzip        int
+		compression protocol.CompressionType
+		signed      bool
+	}{
+		{
+			label: "request with wide cmd code",
+			packet: &protocol.Packet{
+				Metadata: &protocol.Metadata{Type: protocol.RequestPacket, CmdCode: 70000, RequestId: 1, Timeout: 255, Codec: protocol.CodecJSON},
+				Body:     []byte("hello world"),
+			},
+		},
+		{
+			label: "response with metadata and signature",
+			packet: &protocol.Packet{
+				Metadata: &protocol.Metadata{Type: protocol.ResponsePacket, CmdCode: 1, RequestId: 2, StatusCode: 3, Codec: protocol.CodecProtobuf, Values: map[string]string{"trace_id": "abc"}},
+				Body:     []byte("hello world"),
+			},
+			signed: true,
+		},
+		{
+			label: "push larger than v1 limit",
+			packet: &protocol.Packet{
+				Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3, Codec: protocol.CodecProtobuf},
+				Body:     []byte(strings.Repeat("a", 1<<24+1)),
+			},
+		},
+		{
+			label: "compressed push",
+			packet: &protocol.Packet{
+				Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3, Codec: protocol.CodecProtobuf},
+				Body:     []byte(strings.Repeat("hello world", 100)),
+			},
+			gzip: 10,
+		},
+		{
+			label: "push compressed by zstd",
+			packet: &protocol.Packet{
+				Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3, Codec: protocol.CodecProtobuf},
+				Body:     []byte(strings.Repeat("hello world", 100)),
+			},
+			gzip:        10,
+			compression: protocol.CompressionZstd,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			body := c.packet.Body
+
+			if c.signed {
+				protocol.SignPacket(&protocol.Context{Version: 3}, c.packet, signer)
+			}
+
+			data, err := v3.Pack(&protocol.Context{}, c.packet, protocol.GzipSize(c.gzip), protocol.WithCompression(c.compression, protocol.CompressionLevelDefault))
+			assert.Nil(t, err)
+
+			check := func(p *protocol.Packet) {
+				assert.Equal(t, c.packet.Metadata.Type, p.Metadata.Type)
+				assert.Equal(t, c.packet.Metadata.CmdCode, p.Metadata.CmdCode)
+				assert.Equal(t, c.packet.Metadata.RequestId, p.Metadata.RequestId)
+				assert.Equal(t, c.packet.Metadata.StatusCode, p.Metadata.StatusCode)
+				assert.Equal(t, c.packet.Metadata.Codec, p.Metadata.Codec)
+				assert.Equal(t, c.gzip != 0, p.Metadata.Gzip)
+				assert.Equal(t, c.packet.Metadata.Compression, p.Metadata.Compression)
+				assert.Equal(t, len(c.packet.Metadata.Values), len(p.Metadata.Values))
+				assert.Equal(t, body, p.Body)
+
+				if c.signed {
+					assert.Nil(t, protocol.NewVerifier(signer, time.Minute).Verify(p))
+				}
+			}
+
+			p, err := v3.UnpackBytes(&protocol.Context{}, data)
+			assert.Nil(t, err)
+			check(p)
+
+			// feed stream in small chunks, header may be split anywhere
+			ctx := protocol.NewContext(context.Background(), protocol.ClientSide)
+			buf := ringbuffer.New(len(data))
+
+			var done bool
+
+			for i := 0; i < len(data) && !done; {
+				n := 3
+
+				if i > 64 || i+n > len(data) {
+					n = len(data) - i
+				}
+
+				_, _ = buf.Write(data[i : i+n])
+				i += n
+
+				p, done, err = v3.Unpack(ctx, buf)
+				assert.Nil(t, err)
+			}
+
+			assert.True(t, done)
+			assert.Equal(t, 0, buf.Length())
+			check(p)
+		})
+	}
+}
+
+func TestProtocolV3_UnpackBytesInvalid(t *testing.T) {
+	packet := &protocol.Packet{
+		Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3},
+		Body:     []byte("hello world"),
+	}
+
+	data, err := v3.Pack(&protocol.Context{}, packet)
+	assert.Nil(t, err)
+
+	_, err = v3.UnpackBytes(&protocol.Context{}, data[:len(data)-1])
+	assert.NotNil(t, err)
+
+	_, err = v3.UnpackBytes(&protocol.Context{}, data[:2])
+	assert.NotNil(t, err)
+}
+
+func TestProtocolV3_PackFrameLimit(t *testing.T) {
+	defer SetMaxFrameLength(DefaultMaxFrameLength)
+
+	SetMaxFrameLength(12)
+
+	packet := &protocol.Packet{
+		Metadata: &protocol.Metadata{Type: protocol.PushPacket, CmdCode: 3, Values: map[string]string{"k": "v"}},
+		Body:     []byte("hello world"),
+	}
+
+	// metadata is counted
+	_, err := v3.Pack(&protocol.Context{}, packet)
+	assert.Equal(t, v1.ErrBodyLenHitLimit, err)
+
+	packet.Metadata.Values = nil
+
+	_, err = v3.Pack(&protocol.Context{}, packet)
+	assert.Nil(t, err)
+}